@@ -1,73 +1,170 @@
+// Command gophermart runs the loyalty system.
+//
+//	gophermart [flags]                       serve --with-worker --migrate
+//	gophermart serve [--with-worker] [--migrate] [flags]
+//	gophermart worker [flags]
+//	gophermart migrate [flags] up|down|version|force VERSION
 package main
 
 import (
+	"context"
 	"database/sql"
+	"flag"
 	"fmt"
 	"log"
-	"net/http"
+	"os"
+	"strings"
 
 	"github.com/golang-migrate/migrate"
 	"github.com/golang-migrate/migrate/database/postgres"
 	"github.com/tim3-p/go-ya-diplom/config"
 	"github.com/tim3-p/go-ya-diplom/internal/handlers"
-	"github.com/tim3-p/go-ya-diplom/internal/interfaces"
+	applog "github.com/tim3-p/go-ya-diplom/internal/logger"
 	middleware "github.com/tim3-p/go-ya-diplom/internal/middlewares"
-	"github.com/tim3-p/go-ya-diplom/internal/service"
-	"github.com/tim3-p/go-ya-diplom/internal/storage"
+	"github.com/tim3-p/go-ya-diplom/internal/tracing"
+	"github.com/tim3-p/go-ya-diplom/migrations"
+	"go.uber.org/zap"
 
-	_ "github.com/golang-migrate/migrate/source/file"
 	_ "github.com/jackc/pgx/v4/stdlib"
 )
 
+const usage = `usage:
+  gophermart [flags]                        API, accrual worker and migrations in one process
+  gophermart serve [--with-worker] [--migrate] [flags]
+  gophermart worker [flags]
+  gophermart migrate [flags] up|down|version|force VERSION
+`
+
 func main() {
-	cfg := config.InitConfig()
-	db, err := sql.Open("pgx", cfg.DatabasURI)
+	command, args := "", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "":
+		// Without a command everything runs in one process, as before the commands were added.
+		serve(args, true, true)
+	case "serve":
+		serve(args, false, false)
+	case "worker":
+		worker(args)
+	case "migrate":
+		migrateCommand(args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// app holds what every command needs.
+type app struct {
+	cfg             config.Config
+	logger          *zap.Logger
+	db              *sql.DB
+	shutdownTracing func(ctx context.Context) error
+}
+
+func newApp(cfg config.Config, service string) *app {
+	logger, err := applog.New(cfg.LogLevel, cfg.LogFormat)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
+	zap.ReplaceGlobals(logger)
+
+	shutdownTracing, err := tracing.Setup(cfg.TraceExporter, cfg.TraceFile, service)
+	if err != nil {
+		logger.Fatal("could not set up tracing", zap.Error(err))
+	}
+
+	db, err := sql.Open("pgx", cfg.DatabasURI)
+	if err != nil {
+		logger.Fatal("could not open database", zap.Error(err))
+	}
+
+	return &app{cfg: cfg, logger: logger, db: db, shutdownTracing: shutdownTracing}
+}
+
+func (a *app) migrator() *migrate.Migrate {
+	driver, err := postgres.WithInstance(a.db, &postgres.Config{})
+	if err != nil {
+		a.logger.Fatal("could not start sql migration", zap.Error(err))
+	}
 
-	driver, err := postgres.WithInstance(db, &postgres.Config{})
+	src, err := migrations.Source()
 	if err != nil {
-		log.Fatalf("could not start sql migration... %v", err)
+		a.logger.Fatal("could not read migrations", zap.Error(err))
 	}
 
-	m, err := migrate.NewWithDatabaseInstance(fmt.Sprintf("file://%s", cfg.MigrationDir), "product", driver)
+	m, err := migrate.NewWithInstance("go-bindata", src, "product", driver)
 	if err != nil {
-		log.Fatalf("migration failed... %v", err)
+		a.logger.Fatal("migration failed", zap.Error(err))
 	}
 
-	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-		log.Fatalf("An error occurred while syncing the database.. %v", err)
+	return m
+}
+
+func (a *app) expectedMigrationVersion() uint {
+	version, err := migrations.Latest()
+	if err != nil {
+		a.logger.Fatal("could not read migrations", zap.Error(err))
 	}
+	return version
+}
 
-	userRepository := storage.CreateUser(db)
-	orderRepository := storage.CreateOrder(db)
-	withdrawalRepository := storage.CreateWithdrawal(db)
-	cookieAuthenticator := service.NewCookieAuthenticator([]byte(cfg.Key))
-	accrualService := service.NewAccrual(cfg.AccrualSystemAddress, orderRepository)
-	accrualService.Start()
-	authenticator := middleware.NewAuthenticator(cookieAuthenticator)
+// close flushes traces and closes the database, it must be called last.
+func (a *app) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), a.cfg.ShutdownTimeout)
+	defer cancel()
 
-	mws := []interfaces.Middleware{
-		middleware.GzipEncoder{},
-		middleware.GzipDecoder{},
+	if err := a.shutdownTracing(ctx); err != nil {
+		a.logger.Error("could not flush traces", zap.Error(err))
 	}
 
-	handler := handlers.NewHandler(
-		cfg.AccrualSystemAddress,
-		userRepository,
-		orderRepository,
-		withdrawalRepository,
-		cookieAuthenticator,
-		accrualService,
-		authenticator,
-		mws,
-	)
-	server := &http.Server{
-		Addr:    cfg.RunAddress,
-		Handler: handler,
+	if err := a.db.Close(); err != nil {
+		a.logger.Error("could not close database", zap.Error(err))
 	}
+	a.logger.Info("stopped")
+	a.logger.Sync()
+}
 
-	log.Fatal(server.ListenAndServe())
+func newRateLimits(cfg config.Config, logger *zap.Logger) (handlers.RateLimits, error) {
+	trustedProxies, err := middleware.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return handlers.RateLimits{}, err
+	}
+
+	auth, err := middleware.ParseRateLimitPolicy(cfg.RateLimitAuth)
+	if err != nil {
+		return handlers.RateLimits{}, err
+	}
+	if auth.Limit > 0 && len(trustedProxies) == 0 {
+		// Behind a load balancer every client would share the limit of the balancer address.
+		logger.Warn("no trusted proxies are set, the auth rate limit applies to the peer address; set TRUSTED_PROXIES when running behind a proxy")
+	}
+
+	orders, err := middleware.ParseRateLimitPolicy(cfg.RateLimitOrders)
+	if err != nil {
+		return handlers.RateLimits{}, err
+	}
+
+	other, err := middleware.ParseRateLimitPolicy(cfg.RateLimitDefault)
+	if err != nil {
+		return handlers.RateLimits{}, err
+	}
+
+	return handlers.RateLimits{
+		Auth:    middleware.NewRateLimiter("auth", auth, trustedProxies),
+		Orders:  middleware.NewRateLimiter("orders", orders, trustedProxies),
+		Default: middleware.NewRateLimiter("default", other, trustedProxies),
+	}, nil
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	return flags
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/golang-migrate/migrate"
+	"github.com/tim3-p/go-ya-diplom/config"
+	"go.uber.org/zap"
+)
+
+// migrateCommand applies, reverts or inspects the schema migrations and exits.
+func migrateCommand(args []string) {
+	flags := newFlagSet("migrate")
+	cfg := config.InitConfig(flags, args)
+
+	action, arity := flags.Arg(0), map[string]int{"up": 1, "down": 1, "version": 1, "force": 2}
+	if arity[action] == 0 || flags.NArg() != arity[action] {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	a := newApp(cfg, "gophermart-migrate")
+	m := a.migrator()
+
+	var err error
+	switch action {
+	case "up":
+		err = m.Up()
+	case "down":
+		// Only the latest migration is reverted, dropping the whole schema takes a repeated command.
+		err = m.Steps(-1)
+	case "version":
+		var version uint
+		var dirty bool
+		version, dirty, err = m.Version()
+		if err == migrate.ErrNilVersion {
+			version, err = 0, nil
+		}
+		if err == nil {
+			fmt.Printf("version %d, dirty %t\n", version, dirty)
+		}
+	case "force":
+		var version int
+		version, err = strconv.Atoi(flags.Arg(1))
+		if err == nil {
+			err = m.Force(version)
+		}
+	}
+
+	if err == migrate.ErrNoChange {
+		a.logger.Info("no migration to apply")
+		err = nil
+	}
+	if err != nil {
+		a.logger.Fatal("migration failed", zap.Error(err))
+	}
+
+	a.close()
+}
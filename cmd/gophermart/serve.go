@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/golang-migrate/migrate"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tim3-p/go-ya-diplom/config"
+	"github.com/tim3-p/go-ya-diplom/internal/handlers"
+	"github.com/tim3-p/go-ya-diplom/internal/interfaces"
+	"github.com/tim3-p/go-ya-diplom/internal/metrics"
+	middleware "github.com/tim3-p/go-ya-diplom/internal/middlewares"
+	"github.com/tim3-p/go-ya-diplom/internal/secrets"
+	"github.com/tim3-p/go-ya-diplom/internal/service"
+	"github.com/tim3-p/go-ya-diplom/internal/storage"
+	"go.uber.org/zap"
+)
+
+// serve runs the API and the outbox dispatcher. The dispatchers of all replicas share the outbox
+// and relay events to the event streams of every replica.
+func serve(args []string, withWorker, autoMigrate bool) {
+	flags := newFlagSet("serve")
+	flags.BoolVar(&withWorker, "with-worker", withWorker, "Run the accrual worker in this process")
+	flags.BoolVar(&autoMigrate, "migrate", autoMigrate, "Apply migrations before start")
+	cfg := config.InitConfig(flags, args)
+
+	a := newApp(cfg, "gophermart")
+	logger, db := a.logger, a.db
+
+	m := a.migrator()
+	if autoMigrate {
+		if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+			logger.Fatal("an error occurred while syncing the database", zap.Error(err))
+		}
+	}
+
+	eventBus := service.NewEventBus(1000)
+	webhookSecrets, err := secrets.NewBox([]byte(cfg.Key), "webhook secret")
+	if err != nil {
+		logger.Fatal("could not set up webhook secret encryption", zap.Error(err))
+	}
+	webhookRepository := storage.CreateWebhook(db, webhookSecrets)
+	webhookService := service.NewWebhooks(webhookRepository, time.Second, logger.Named("webhooks"))
+	webhookService.Start()
+	outboxDispatcher := service.NewOutboxDispatcher(storage.CreateOutbox(db), 500*time.Millisecond, logger.Named("outbox"))
+	eventChannel := storage.CreateEventChannel(db)
+	outboxDispatcher.Subscribe(eventChannel)
+	outboxDispatcher.Subscribe(webhookService)
+	outboxDispatcher.Start()
+	background, stopBackground := context.WithCancel(context.Background())
+	go eventBus.Relay(background, eventChannel, logger.Named("events"))
+	userRepository := storage.CreateUser(db)
+	orderRepository := storage.CreateOrder(db)
+	withdrawalRepository := storage.CreateWithdrawal(db)
+	idempotencyRepository := storage.CreateIdempotency(db)
+	cookieAuthenticator := service.NewCookieAuthenticator([]byte(cfg.Key))
+
+	var accrualService *service.Accrual
+	var pointAccrualService interfaces.PointAccrualService = service.NewAccrualRequeuer(orderRepository)
+	var accrualStatus service.AccrualStatusProvider
+	if withWorker {
+		accrualService = service.NewAccrual(cfg.AccrualSystemAddress, orderRepository, cfg.AccrualScanInterval, logger.Named("accrual"))
+		accrualService.Start()
+		pointAccrualService, accrualStatus = accrualService, accrualService
+		metrics.RegisterAccrualQueue(accrualService.QueueLength)
+	}
+
+	health := service.NewHealth(db, m.Version, a.expectedMigrationVersion(), accrualStatus, logger.Named("health"))
+	metrics.RegisterDB(db)
+	authenticator := middleware.NewAuthenticator(cookieAuthenticator, userRepository)
+	orderPrefixes, err := service.ParseAllowedPrefixes(cfg.OrderNumberPrefixes)
+	if err != nil {
+		logger.Fatal("invalid order number prefixes", zap.Error(err))
+	}
+	orderValidator := service.NewOrderNumberValidator(cfg.OrderNumberMinLength, cfg.OrderNumberMaxLength, orderPrefixes)
+	idempotency := middleware.NewIdempotency(idempotencyRepository)
+	go idempotency.RunCleanup(background, time.Hour, logger.Named("idempotency"))
+	rateLimits, err := newRateLimits(cfg, logger)
+	if err != nil {
+		logger.Fatal("invalid rate limits", zap.Error(err))
+	}
+
+	mws := []interfaces.Middleware{
+		middleware.NewCompressor(cfg.CompressMinSize, middleware.DefaultCompressibleTypes),
+		middleware.Decompressor{},
+	}
+
+	handler := handlers.NewHandler(
+		cfg.AccrualSystemAddress,
+		cfg.BatchOrderLimit,
+		userRepository,
+		orderRepository,
+		withdrawalRepository,
+		webhookRepository,
+		cookieAuthenticator,
+		pointAccrualService,
+		orderValidator,
+		eventBus,
+		health,
+		authenticator,
+		idempotency,
+		rateLimits,
+		mws,
+	)
+
+	requestID := middleware.NewRequestID(logger.Named("http"))
+	server := &http.Server{
+		Addr:    cfg.RunAddress,
+		Handler: requestID.Handle(middleware.AccessLog{}.Handle(middleware.Metrics{}.Handle(middleware.Tracing{}.Handle(handler.ServeHTTP)))),
+	}
+
+	// Event streams never end by themselves, they are closed when the shutdown starts.
+	server.RegisterOnShutdown(eventBus.Close)
+
+	// Metrics are served on their own listener, away from the public API.
+	metricsServer := &http.Server{
+		Addr:    cfg.MetricsAddress,
+		Handler: promhttp.Handler(),
+	}
+
+	runServers(a, server, metricsServer)
+
+	if accrualService != nil {
+		stopWorker(a, "accrual worker", accrualService.Stop)
+	}
+	stopWorker(a, "outbox dispatcher", outboxDispatcher.Stop)
+	stopWorker(a, "webhook sender", webhookService.Stop)
+	stopBackground()
+
+	a.close()
+}
+
+// runServers serves until SIGINT or SIGTERM, or until one of the servers fails,
+// and drains the requests in flight.
+func runServers(a *app, servers ...*http.Server) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, len(servers))
+	for _, server := range servers {
+		server := server
+		go func() {
+			a.logger.Info("starting server", zap.String("address", server.Addr))
+			serverErr <- server.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-serverErr:
+		a.logger.Error("server stopped", zap.Error(err))
+	case <-ctx.Done():
+		a.logger.Info("shutting down")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), a.cfg.ShutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			a.logger.Error("could not drain http requests", zap.String("address", server.Addr), zap.Error(err))
+		}
+	}
+}
+
+// stopWorker gives a background worker the configured time to finish its current job.
+func stopWorker(a *app, name string, stop func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.cfg.WorkerStopTimeout)
+	defer cancel()
+
+	if err := stop(ctx); err != nil {
+		a.logger.Error(name+" did not finish the current job", zap.Error(err))
+	}
+}
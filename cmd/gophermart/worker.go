@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tim3-p/go-ya-diplom/config"
+	"github.com/tim3-p/go-ya-diplom/internal/handlers"
+	"github.com/tim3-p/go-ya-diplom/internal/metrics"
+	middleware "github.com/tim3-p/go-ya-diplom/internal/middlewares"
+	"github.com/tim3-p/go-ya-diplom/internal/service"
+	"github.com/tim3-p/go-ya-diplom/internal/storage"
+)
+
+// worker polls the accrual system for the orders uploaded through serve. It listens on
+// the run address only for the probes, and on the metrics address for the metrics.
+func worker(args []string) {
+	cfg := config.InitConfig(newFlagSet("worker"), args)
+
+	a := newApp(cfg, "gophermart-worker")
+	logger, db := a.logger, a.db
+
+	orderRepository := storage.CreateOrder(db)
+	accrualService := service.NewAccrual(cfg.AccrualSystemAddress, orderRepository, cfg.AccrualScanInterval, logger.Named("accrual"))
+	accrualService.Start()
+
+	health := service.NewHealth(db, a.migrator().Version, a.expectedMigrationVersion(), accrualService, logger.Named("health"))
+	metrics.RegisterDB(db)
+	metrics.RegisterAccrualQueue(accrualService.QueueLength)
+
+	handler := handlers.NewProbes(health)
+
+	requestID := middleware.NewRequestID(logger.Named("http"))
+	server := &http.Server{
+		Addr:    cfg.RunAddress,
+		Handler: requestID.Handle(middleware.AccessLog{}.Handle(handler.ServeHTTP)),
+	}
+
+	// Metrics are served on their own listener, as in serve.
+	metricsServer := &http.Server{
+		Addr:    cfg.MetricsAddress,
+		Handler: promhttp.Handler(),
+	}
+
+	runServers(a, server, metricsServer)
+	stopWorker(a, "accrual worker", accrualService.Stop)
+
+	a.close()
+}
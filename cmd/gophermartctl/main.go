@@ -0,0 +1,199 @@
+// Command gophermartctl is the administration tool of gophermart. It works with the database directly.
+//
+//	gophermartctl [-d uri] [-o table|json] <command>
+//
+// Commands:
+//
+//	user show LOGIN
+//	user lock LOGIN
+//	user unlock LOGIN
+//	orders list [-status NEW,REGISTERED,PROCESSING] [-login LOGIN] [-limit 50]
+//	orders requeue [-stuck-after 10m] [NUMBER...]
+//	balance adjust -reason REASON LOGIN AMOUNT
+//	migrate version
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tim3-p/go-ya-diplom/internal/models"
+	"github.com/tim3-p/go-ya-diplom/internal/storage"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+const usage = `usage: gophermartctl [-d uri] [-o table|json] <command>
+
+commands:
+  user show LOGIN
+  user lock LOGIN
+  user unlock LOGIN
+  orders list [-status NEW,PROCESSING] [-login LOGIN] [-limit 50]
+  orders requeue [-stuck-after 10m] [NUMBER...]
+  balance adjust -reason REASON LOGIN AMOUNT
+  migrate version
+`
+
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	flags := flag.NewFlagSet("gophermartctl", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	databaseURI := flags.String("d", os.Getenv("DATABASE_URI"), "Database URI")
+	format := flags.String("o", "table", "Output format: table or json")
+	flags.Parse(os.Args[1:])
+
+	out, err := newOutput(os.Stdout, *format)
+	if err != nil {
+		fail(err)
+	}
+
+	db, err := sql.Open("pgx", *databaseURI)
+	if err != nil {
+		fail(err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	err = run(ctx, storage.CreateAdmin(db), out, flags.Args())
+	if errors.Is(err, errUsage) {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		err = errors.New("not found")
+	}
+	fmt.Fprintln(os.Stderr, "gophermartctl:", err)
+	os.Exit(1)
+}
+
+func run(ctx context.Context, admin *storage.Admin, out *output, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+
+	command, args := args[0]+" "+args[1], args[2:]
+	switch command {
+	case "user show":
+		if len(args) != 1 {
+			return errUsage
+		}
+		user, err := admin.GetUser(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		return out.user(user)
+	case "user lock", "user unlock":
+		if len(args) != 1 {
+			return errUsage
+		}
+		if err := admin.SetLocked(ctx, args[0], command == "user lock"); err != nil {
+			return err
+		}
+		user, err := admin.GetUser(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		return out.user(user)
+	case "orders list":
+		return listOrders(ctx, admin, out, args)
+	case "orders requeue":
+		return requeueOrders(ctx, admin, out, args)
+	case "balance adjust":
+		return adjustBalance(ctx, admin, out, args)
+	case "migrate version":
+		version, err := admin.MigrationVersion(ctx)
+		if err != nil {
+			return err
+		}
+		return out.migrationVersion(version)
+	}
+
+	return errUsage
+}
+
+func listOrders(ctx context.Context, admin *storage.Admin, out *output, args []string) error {
+	flags := flag.NewFlagSet("orders list", flag.ContinueOnError)
+	status := flags.String("status", "", "Comma separated statuses")
+	login := flags.String("login", "", "Orders of the user")
+	limit := flags.Int("limit", 50, "Maximum number of orders")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 || *limit <= 0 {
+		return errUsage
+	}
+
+	var statuses []string
+	for _, value := range strings.Split(*status, ",") {
+		value = strings.ToUpper(strings.TrimSpace(value))
+		switch value {
+		case "":
+		case models.New, models.Processing, models.Invalid, models.Processed:
+			statuses = append(statuses, value)
+		case models.Registered:
+			// Orders registered by the accrual system are stored as NEW.
+			statuses = append(statuses, models.New)
+		default:
+			return fmt.Errorf("unknown status %q", value)
+		}
+	}
+
+	orders, err := admin.ListOrders(ctx, statuses, *login, *limit)
+	if err != nil {
+		return err
+	}
+	return out.orders(orders)
+}
+
+func requeueOrders(ctx context.Context, admin *storage.Admin, out *output, args []string) error {
+	flags := flag.NewFlagSet("orders requeue", flag.ContinueOnError)
+	stuckAfter := flags.Duration("stuck-after", 10*time.Minute, "Requeue pending orders not checked for this long")
+	if err := flags.Parse(args); err != nil || *stuckAfter <= 0 {
+		return errUsage
+	}
+
+	numbers, err := admin.Requeue(ctx, flags.Args(), *stuckAfter)
+	if err != nil {
+		return err
+	}
+	return out.requeued(numbers)
+}
+
+func adjustBalance(ctx context.Context, admin *storage.Admin, out *output, args []string) error {
+	flags := flag.NewFlagSet("balance adjust", flag.ContinueOnError)
+	reason := flags.String("reason", "", "Reason of the adjustment, required")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 2 {
+		return errUsage
+	}
+	if strings.TrimSpace(*reason) == "" {
+		return errors.New("reason is required")
+	}
+
+	amount, err := strconv.ParseFloat(flags.Arg(1), 64)
+	if err != nil || amount == 0 {
+		return fmt.Errorf("invalid amount %q", flags.Arg(1))
+	}
+
+	adjustment, err := admin.AdjustBalance(ctx, flags.Arg(0), amount, *reason)
+	if errors.Is(err, storage.ErrInsufficientBalance) {
+		return errors.New("the balance would become negative")
+	}
+	if err != nil {
+		return err
+	}
+	return out.adjustment(adjustment)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tim3-p/go-ya-diplom/internal/models"
+)
+
+// output prints results as an aligned table or as indented JSON.
+type output struct {
+	w    io.Writer
+	json bool
+}
+
+func newOutput(w io.Writer, format string) (*output, error) {
+	switch format {
+	case "table":
+		return &output{w: w}, nil
+	case "json":
+		return &output{w: w, json: true}, nil
+	}
+
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
+
+func (o *output) write(value interface{}, header []string, rows [][]string) error {
+	if o.json {
+		encoder := json.NewEncoder(o.w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+
+	tw := tabwriter.NewWriter(o.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func (o *output) user(user models.UserSummary) error {
+	header := []string{"ID", "LOGIN", "BALANCE", "WITHDRAWN", "ORDERS", "WITHDRAWALS", "LOCKED AT"}
+	row := []string{
+		strconv.FormatUint(user.ID, 10),
+		user.Login,
+		formatAmount(user.Balance),
+		formatAmount(user.Withdrawn),
+		strconv.Itoa(user.Orders),
+		strconv.Itoa(user.Withdrawals),
+		formatTime(user.LockedAt),
+	}
+	return o.write(user, header, [][]string{row})
+}
+
+func (o *output) orders(orders []models.OrderSummary) error {
+	header := []string{"NUMBER", "LOGIN", "STATUS", "ACCRUAL", "POLLS", "CREATED AT", "LAST CHECKED AT"}
+	rows := make([][]string, len(orders))
+	for i, order := range orders {
+		rows[i] = []string{
+			order.Number,
+			order.Login,
+			order.Status,
+			formatAmount(order.Accrual),
+			strconv.Itoa(order.PollAttempts),
+			formatTime(&order.CreatedAt),
+			formatTime(order.LastCheckedAt),
+		}
+	}
+	return o.write(orders, header, rows)
+}
+
+func (o *output) requeued(numbers []string) error {
+	rows := make([][]string, len(numbers))
+	for i, number := range numbers {
+		rows[i] = []string{number}
+	}
+	return o.write(struct {
+		Requeued []string `json:"requeued"`
+	}{numbers}, []string{"REQUEUED"}, rows)
+}
+
+func (o *output) adjustment(adjustment models.BalanceAdjustment) error {
+	header := []string{"LOGIN", "AMOUNT", "BALANCE", "REASON", "CREATED AT"}
+	row := []string{
+		adjustment.Login,
+		formatAmount(adjustment.Amount),
+		formatAmount(adjustment.Balance),
+		adjustment.Reason,
+		formatTime(&adjustment.CreatedAt),
+	}
+	return o.write(adjustment, header, [][]string{row})
+}
+
+func (o *output) migrationVersion(version models.MigrationVersion) error {
+	row := []string{strconv.FormatUint(uint64(version.Version), 10), strconv.FormatBool(version.Dirty)}
+	return o.write(version, []string{"VERSION", "DIRTY"}, [][]string{row})
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -3,25 +3,58 @@ package config
 import (
 	"flag"
 	"log"
+	"time"
 
 	"github.com/caarlos0/env"
 )
 
 type Config struct {
-	RunAddress           string `env:"RUN_ADDRESS"`
-	DatabasURI           string `env:"DATABASE_URI"`
-	AccrualSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	RunAddress           string        `env:"RUN_ADDRESS"`
+	DatabasURI           string        `env:"DATABASE_URI"`
+	AccrualSystemAddress string        `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	BatchOrderLimit      int           `env:"BATCH_ORDER_LIMIT"`
+	OrderNumberMinLength int           `env:"ORDER_NUMBER_MIN_LENGTH"`
+	OrderNumberMaxLength int           `env:"ORDER_NUMBER_MAX_LENGTH"`
+	OrderNumberPrefixes  string        `env:"ORDER_NUMBER_PREFIXES"`
+	LogLevel             string        `env:"LOG_LEVEL"`
+	LogFormat            string        `env:"LOG_FORMAT"`
+	TraceExporter        string        `env:"TRACE_EXPORTER"`
+	TraceFile            string        `env:"TRACE_FILE"`
+	ShutdownTimeout      time.Duration `env:"SHUTDOWN_TIMEOUT"`
+	WorkerStopTimeout    time.Duration `env:"WORKER_STOP_TIMEOUT"`
+	RateLimitAuth        string        `env:"RATE_LIMIT_AUTH"`
+	RateLimitOrders      string        `env:"RATE_LIMIT_ORDERS"`
+	RateLimitDefault     string        `env:"RATE_LIMIT_DEFAULT"`
+	TrustedProxies       string        `env:"TRUSTED_PROXIES"`
+	CompressMinSize      int           `env:"COMPRESS_MIN_SIZE"`
+	AccrualScanInterval  time.Duration `env:"ACCRUAL_SCAN_INTERVAL"`
+	MetricsAddress       string        `env:"METRICS_ADDRESS"`
 	Key                  string
-	MigrationDir         string
 }
 
-func InitConfig() Config {
+// InitConfig reads the environment and then the flags from args. Commands register their own
+// flags on the set before the call.
+func InitConfig(flags *flag.FlagSet, args []string) Config {
 	var cfg = Config{
 		RunAddress:           "http://localhost:8080",
 		DatabasURI:           "",
 		AccrualSystemAddress: "",
+		BatchOrderLimit:      100,
+		OrderNumberMinLength: 1,
+		OrderNumberMaxLength: 255,
+		LogLevel:             "info",
+		LogFormat:            "json",
+		TraceExporter:        "none",
+		TraceFile:            "traces.json",
+		ShutdownTimeout:      15 * time.Second,
+		WorkerStopTimeout:    10 * time.Second,
+		RateLimitAuth:        "10/1m",
+		RateLimitOrders:      "60/1m",
+		RateLimitDefault:     "600/1m",
+		CompressMinSize:      1024,
+		AccrualScanInterval:  10 * time.Second,
+		MetricsAddress:       "localhost:9090",
 		Key:                  "MySecretKey",
-		MigrationDir:         "./migrations",
 	}
 
 	err := env.Parse(&cfg)
@@ -29,10 +62,23 @@ func InitConfig() Config {
 		log.Fatal(err)
 	}
 
-	flag.StringVar(&cfg.RunAddress, "a", cfg.RunAddress, "Run address")
-	flag.StringVar(&cfg.DatabasURI, "d", cfg.DatabasURI, "Database URI")
-	flag.StringVar(&cfg.AccrualSystemAddress, "r", cfg.AccrualSystemAddress, "Accrual system address")
-	flag.Parse()
+	flags.StringVar(&cfg.RunAddress, "a", cfg.RunAddress, "Run address")
+	flags.StringVar(&cfg.DatabasURI, "d", cfg.DatabasURI, "Database URI")
+	flags.StringVar(&cfg.AccrualSystemAddress, "r", cfg.AccrualSystemAddress, "Accrual system address")
+	flags.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "Log level: debug, info, warn or error")
+	flags.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "Log format: json or console")
+	flags.StringVar(&cfg.TraceExporter, "trace-exporter", cfg.TraceExporter, "Trace exporter: none, stdout or file")
+	flags.StringVar(&cfg.TraceFile, "trace-file", cfg.TraceFile, "File for the file trace exporter")
+	flags.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "Time to drain http requests on shutdown")
+	flags.DurationVar(&cfg.WorkerStopTimeout, "worker-stop-timeout", cfg.WorkerStopTimeout, "Time for the accrual worker to finish the current job on shutdown")
+	flags.StringVar(&cfg.RateLimitAuth, "rate-limit-auth", cfg.RateLimitAuth, "Register and login requests per IP, like 10/1m, 0 disables the limit")
+	flags.StringVar(&cfg.RateLimitOrders, "rate-limit-orders", cfg.RateLimitOrders, "Order uploads per user, like 60/1m, 0 disables the limit")
+	flags.StringVar(&cfg.RateLimitDefault, "rate-limit-default", cfg.RateLimitDefault, "Other requests per user, like 600/1m, 0 disables the limit")
+	flags.StringVar(&cfg.TrustedProxies, "trusted-proxies", cfg.TrustedProxies, "Comma separated proxy addresses or networks allowed to set X-Forwarded-For, required behind a proxy for the per IP limits")
+	flags.IntVar(&cfg.CompressMinSize, "compress-min-size", cfg.CompressMinSize, "Responses smaller than this number of bytes are not compressed")
+	flags.DurationVar(&cfg.AccrualScanInterval, "accrual-scan-interval", cfg.AccrualScanInterval, "How often the accrual worker looks for orders queued by other processes")
+	flags.StringVar(&cfg.MetricsAddress, "metrics-address", cfg.MetricsAddress, "Address of the Prometheus metrics listener, keep it off the public network")
+	flags.Parse(args)
 
 	return cfg
 }
@@ -0,0 +1,391 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tim3-p/go-ya-diplom/internal/handlers"
+	"github.com/tim3-p/go-ya-diplom/internal/handlers/handlerstest"
+)
+
+// The contract test drives the handler through every documented operation and checks that
+// the status code, the content type and the body of each response are described by openapi.json.
+
+type spec struct {
+	Paths      map[string]map[string]operation `json:"paths"`
+	Components struct {
+		Responses map[string]response               `json:"responses"`
+		Schemas   map[string]map[string]interface{} `json:"schemas"`
+	} `json:"components"`
+}
+
+type operation struct {
+	Responses map[string]response `json:"responses"`
+}
+
+type response struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema map[string]interface{} `json:"schema"`
+	} `json:"content"`
+}
+
+type contract struct {
+	t       *testing.T
+	spec    spec
+	server  *httptest.Server
+	covered map[string]bool
+}
+
+func newContract(t *testing.T, store *handlerstest.Store) *contract {
+	server := httptest.NewServer(handlerstest.NewHandler(store))
+	t.Cleanup(server.Close)
+
+	c := &contract{t: t, server: server, covered: map[string]bool{}}
+	body, _ := c.call(http.DefaultClient, http.MethodGet, "/api/openapi.json", "/api/openapi.json", "", "", http.StatusOK)
+	if err := json.Unmarshal(body, &c.spec); err != nil {
+		t.Fatalf("invalid openapi.json: %v", err)
+	}
+	c.covered["GET /api/openapi.json"] = true
+
+	return c
+}
+
+func (c *contract) client() *http.Client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return &http.Client{Jar: jar}
+}
+
+// call sends the request and checks the response against the operation of route.
+func (c *contract) call(client *http.Client, method, route, path, contentType, body string, status int) ([]byte, *http.Response) {
+	c.t.Helper()
+
+	request, err := http.NewRequest(method, c.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := client.Do(request)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	payload, err := io.ReadAll(res.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	name := method + " " + route
+	if res.StatusCode != status {
+		c.t.Errorf("%s: status %d, want %d: %s", name, res.StatusCode, status, payload)
+		return payload, res
+	}
+
+	// The document itself is fetched before it is parsed.
+	if c.spec.Paths == nil {
+		return payload, res
+	}
+	c.covered[name] = true
+
+	op, ok := c.spec.Paths[route][strings.ToLower(method)]
+	if !ok {
+		c.t.Errorf("%s is not documented", name)
+		return payload, res
+	}
+
+	documented, ok := op.Responses[fmt.Sprint(status)]
+	if !ok {
+		c.t.Errorf("%s: status %d is not documented", name, status)
+		return payload, res
+	}
+	if documented.Ref != "" {
+		documented = c.spec.Components.Responses[strings.TrimPrefix(documented.Ref, "#/components/responses/")]
+	}
+
+	if len(documented.Content) == 0 {
+		if len(payload) > 0 {
+			c.t.Errorf("%s: %d is documented without a body, got %s", name, status, payload)
+		}
+		return payload, res
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	content, ok := documented.Content[mediaType]
+	if !ok {
+		c.t.Errorf("%s: content type %q of %d is not documented", name, mediaType, status)
+		return payload, res
+	}
+
+	var value interface{} = string(payload)
+	if strings.HasSuffix(mediaType, "json") {
+		value = nil
+		err = json.Unmarshal(payload, &value)
+	}
+	if err != nil {
+		c.t.Errorf("%s: invalid JSON %s: %v", name, payload, err)
+		return payload, res
+	}
+	for _, problem := range c.validate(content.Schema, value, "body", true) {
+		c.t.Errorf("%s: %s", name, problem)
+	}
+
+	return payload, res
+}
+
+// validate supports the subset of JSON Schema used by openapi.json. Strict validation reports
+// object properties missing in the schema, it is turned off for the parts of allOf.
+func (c *contract) validate(schema map[string]interface{}, value interface{}, at string, strict bool) []string {
+	schema = c.resolve(schema)
+
+	var problems []string
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		documented := map[string]bool{}
+		for _, part := range all {
+			part := c.resolve(part.(map[string]interface{}))
+			problems = append(problems, c.validate(part, value, at, false)...)
+			properties, _ := part["properties"].(map[string]interface{})
+			for name := range properties {
+				documented[name] = true
+			}
+		}
+		if object, ok := value.(map[string]interface{}); ok && strict {
+			for name := range object {
+				if !documented[name] {
+					problems = append(problems, fmt.Sprintf("%s: %s is not documented", at, name))
+				}
+			}
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return append(problems, fmt.Sprintf("%s: %v is not an object", at, value))
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: %s is required", at, name))
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, property := range object {
+			if propertySchema, ok := properties[name].(map[string]interface{}); ok {
+				problems = append(problems, c.validate(propertySchema, property, at+"."+name, true)...)
+			} else if strict && properties != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s is not documented", at, name))
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return append(problems, fmt.Sprintf("%s: %v is not an array", at, value))
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range array {
+				problems = append(problems, c.validate(items, item, fmt.Sprintf("%s[%d]", at, i), true)...)
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return append(problems, fmt.Sprintf("%s: %v is not a string", at, value))
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a date-time", at, s))
+			}
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			problems = append(problems, fmt.Sprintf("%s: %v is not a number", at, value))
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			problems = append(problems, fmt.Sprintf("%s: %v is not an integer", at, value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			problems = append(problems, fmt.Sprintf("%s: %v is not a boolean", at, value))
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || allowed == value
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s: %v is not one of %v", at, value, enum))
+		}
+	}
+
+	return problems
+}
+
+func (c *contract) resolve(schema map[string]interface{}) map[string]interface{} {
+	if ref, ok := schema["$ref"].(string); ok {
+		return c.resolve(c.spec.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")])
+	}
+	return schema
+}
+
+func TestRoutesDocumented(t *testing.T) {
+	if err := handlers.CheckOpenAPI(handlerstest.NewHandler(handlerstest.NewStore())); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestContract(t *testing.T) {
+	store := handlerstest.NewStore()
+	c := newContract(t, store)
+	alice, bob, anonymous, locked := c.client(), c.client(), c.client(), c.client()
+
+	const (
+		jsonType = "application/json"
+		textType = "text/plain"
+		order    = "12345678903"
+		other    = "79927398713"
+	)
+
+	c.call(anonymous, http.MethodGet, "/healthz", "/healthz", "", "", http.StatusOK)
+	c.call(anonymous, http.MethodGet, "/readyz", "/readyz", "", "", http.StatusOK)
+	c.call(anonymous, http.MethodGet, "/status", "/status", "", "", http.StatusOK)
+
+	c.call(alice, http.MethodPost, "/api/user/register", "/api/user/register", jsonType, `{"login":"alice","password":"password1"}`, http.StatusOK)
+	c.call(alice, http.MethodPost, "/api/user/register", "/api/user/register", jsonType, `{"login":"alice","password":"password1"}`, http.StatusConflict)
+	c.call(alice, http.MethodPost, "/api/user/register", "/api/user/register", jsonType, `{"login":`, http.StatusBadRequest)
+	c.call(alice, http.MethodPost, "/api/user/register", "/api/user/register", textType, `login`, http.StatusUnsupportedMediaType)
+	c.call(alice, http.MethodPost, "/api/user/login", "/api/user/login", jsonType, `{"login":"alice","password":"password1"}`, http.StatusOK)
+	c.call(anonymous, http.MethodPost, "/api/user/login", "/api/user/login", jsonType, `{"login":"alice","password":"wrong"}`, http.StatusUnauthorized)
+	c.call(bob, http.MethodPost, "/api/v2/user/register", "/api/v2/user/register", jsonType, `{"login":"bob","password":"password1"}`, http.StatusOK)
+	c.call(bob, http.MethodPost, "/api/v2/user/login", "/api/v2/user/login", jsonType, `{"login":"bob","password":"password1"}`, http.StatusOK)
+
+	c.call(alice, http.MethodGet, "/api/user/orders", "/api/user/orders", "", "", http.StatusNoContent)
+	c.call(alice, http.MethodGet, "/api/v2/user/orders", "/api/v2/user/orders", "", "", http.StatusNoContent)
+	c.call(alice, http.MethodPost, "/api/user/orders", "/api/user/orders", textType, order, http.StatusAccepted)
+	c.call(alice, http.MethodPost, "/api/user/orders", "/api/user/orders", textType, order, http.StatusOK)
+	c.call(alice, http.MethodPost, "/api/user/orders", "/api/user/orders", textType, "12345678900", http.StatusUnprocessableEntity)
+	c.call(alice, http.MethodPost, "/api/user/orders", "/api/user/orders", jsonType, order, http.StatusUnsupportedMediaType)
+	c.call(bob, http.MethodPost, "/api/v2/user/orders", "/api/v2/user/orders", textType, order, http.StatusConflict)
+	c.call(bob, http.MethodPost, "/api/v2/user/orders", "/api/v2/user/orders", textType, other, http.StatusAccepted)
+	c.call(alice, http.MethodPost, "/api/user/orders/batch", "/api/user/orders/batch", jsonType, `["4561261212345467","`+order+`","`+other+`","1"]`, http.StatusAccepted)
+	c.call(alice, http.MethodPost, "/api/user/orders/batch", "/api/user/orders/batch", jsonType, `["`+order+`"]`, http.StatusOK)
+	c.call(anonymous, http.MethodPost, "/api/user/orders", "/api/user/orders", textType, order, http.StatusUnauthorized)
+	store.Process(order, 500)
+
+	c.call(alice, http.MethodGet, "/api/user/orders", "/api/user/orders?limit=1", "", "", http.StatusOK)
+	c.call(alice, http.MethodGet, "/api/user/orders", "/api/user/orders?limit=0", "", "", http.StatusBadRequest)
+	c.call(alice, http.MethodGet, "/api/v2/user/orders", "/api/v2/user/orders?status=PROCESSED", "", "", http.StatusOK)
+	c.call(alice, http.MethodGet, "/api/user/orders/{number}", "/api/user/orders/"+order, "", "", http.StatusOK)
+	c.call(alice, http.MethodGet, "/api/user/orders/{number}", "/api/user/orders/"+other, "", "", http.StatusForbidden)
+	c.call(alice, http.MethodGet, "/api/user/orders/{number}", "/api/user/orders/4111111111111111", "", "", http.StatusNotFound)
+
+	c.call(alice, http.MethodGet, "/api/user/balance", "/api/user/balance", "", "", http.StatusOK)
+	c.call(alice, http.MethodGet, "/api/v2/user/balance", "/api/v2/user/balance", "", "", http.StatusOK)
+	c.call(alice, http.MethodGet, "/api/user/balance/withdrawals", "/api/user/balance/withdrawals", "", "", http.StatusNoContent)
+	c.call(alice, http.MethodPost, "/api/user/balance/withdraw", "/api/user/balance/withdraw", jsonType, `{"order":"2377225624","sum":100}`, http.StatusOK)
+	c.call(bob, http.MethodPost, "/api/v2/user/balance/withdraw", "/api/v2/user/balance/withdraw", jsonType, `{"order":"2377225624","sum":100}`, http.StatusPaymentRequired)
+	c.call(alice, http.MethodPost, "/api/v2/user/balance/withdraw", "/api/v2/user/balance/withdraw", jsonType, `{"order":"2377225624","sum":-1}`, http.StatusUnprocessableEntity)
+	c.call(alice, http.MethodGet, "/api/user/balance/withdrawals", "/api/user/balance/withdrawals", "", "", http.StatusOK)
+	c.call(alice, http.MethodGet, "/api/v2/user/balance/withdrawals", "/api/v2/user/balance/withdrawals", "", "", http.StatusOK)
+	c.call(alice, http.MethodGet, "/api/user/balance/history", "/api/user/balance/history", "", "", http.StatusOK)
+	c.call(alice, http.MethodGet, "/api/user/balance/history", "/api/user/balance/history?after=unknown", "", "", http.StatusBadRequest)
+	var history struct {
+		Entries []struct {
+			Type    string  `json:"type"`
+			Balance float64 `json:"balance"`
+		} `json:"entries"`
+		NextCursor string `json:"next_cursor"`
+	}
+	body, _ := c.call(alice, http.MethodGet, "/api/user/balance/history", "/api/user/balance/history?limit=1", "", "", http.StatusOK)
+	if err := json.Unmarshal(body, &history); err != nil || len(history.Entries) != 1 || history.NextCursor == "" {
+		t.Fatalf("first history page %s: %v, want one entry and a cursor", body, err)
+	}
+	body, _ = c.call(alice, http.MethodGet, "/api/user/balance/history", "/api/user/balance/history?limit=1&after="+history.NextCursor, "", "", http.StatusOK)
+	history.NextCursor = ""
+	if err := json.Unmarshal(body, &history); err != nil || len(history.Entries) != 1 || history.Entries[0].Type != "debit" || history.Entries[0].Balance != 400 || history.NextCursor != "" {
+		t.Errorf("last history page %s: %v, want the withdrawal with the balance after it", body, err)
+	}
+
+	body, _ = c.call(alice, http.MethodPost, "/api/user/webhooks", "/api/user/webhooks", jsonType, `{"url":"https://93.184.216.34/hook","events":["order.processed"]}`, http.StatusCreated)
+	c.call(alice, http.MethodPost, "/api/user/webhooks", "/api/user/webhooks", jsonType, `{"url":"http://127.0.0.1/hook"}`, http.StatusUnprocessableEntity)
+	var webhook struct {
+		ID uint64 `json:"id"`
+	}
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		t.Fatalf("invalid webhook %s: %v", body, err)
+	}
+	webhookPath := fmt.Sprintf("/api/user/webhooks/%d", webhook.ID)
+	c.call(alice, http.MethodGet, "/api/user/webhooks", "/api/user/webhooks", "", "", http.StatusOK)
+	c.call(alice, http.MethodGet, "/api/user/webhooks/{id}/deliveries", webhookPath+"/deliveries", "", "", http.StatusOK)
+	c.call(bob, http.MethodGet, "/api/user/webhooks/{id}/deliveries", webhookPath+"/deliveries", "", "", http.StatusNotFound)
+	c.call(alice, http.MethodPost, "/api/user/webhooks/{id}/deliveries/{delivery}/redeliver", webhookPath+"/deliveries/1/redeliver", "", "", http.StatusNotFound)
+	c.call(alice, http.MethodDelete, "/api/user/webhooks/{id}", "/api/user/webhooks/abc", "", "", http.StatusBadRequest)
+	c.call(alice, http.MethodDelete, "/api/user/webhooks/{id}", webhookPath, "", "", http.StatusNoContent)
+	c.call(alice, http.MethodDelete, "/api/user/webhooks/{id}", webhookPath, "", "", http.StatusNotFound)
+
+	c.call(locked, http.MethodPost, "/api/user/register", "/api/user/register", jsonType, `{"login":"mallory","password":"password1"}`, http.StatusOK)
+	store.Lock("mallory")
+	c.call(locked, http.MethodGet, "/api/user/balance", "/api/user/balance", "", "", http.StatusForbidden)
+	c.call(locked, http.MethodPost, "/api/user/login", "/api/user/login", jsonType, `{"login":"mallory","password":"password1"}`, http.StatusForbidden)
+
+	// The event stream never ends by itself, its schema is not checked here.
+	var missing []string
+	for path, operations := range c.spec.Paths {
+		for method := range operations {
+			name := strings.ToUpper(method) + " " + path
+			if !c.covered[name] && name != "GET /api/user/orders/events" {
+				missing = append(missing, name)
+			}
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		t.Errorf("operations not covered by the contract test: %s", strings.Join(missing, ", "))
+	}
+}
+
+func TestContractIdempotentReplay(t *testing.T) {
+	c := newContract(t, handlerstest.NewStore())
+	alice := c.client()
+	c.call(alice, http.MethodPost, "/api/user/register", "/api/user/register", "application/json", `{"login":"alice","password":"password1"}`, http.StatusOK)
+
+	send := func() *http.Response {
+		request, _ := http.NewRequest(http.MethodPost, c.server.URL+"/api/user/orders", bytes.NewBufferString("12345678903"))
+		request.Header.Set("Content-Type", "text/plain")
+		request.Header.Set("Idempotency-Key", "key")
+		res, err := alice.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	if res := send(); res.StatusCode != http.StatusAccepted {
+		t.Fatalf("first upload: status %d, want %d", res.StatusCode, http.StatusAccepted)
+	}
+	res := send()
+	if res.StatusCode != http.StatusAccepted || res.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("repeated upload: status %d, replayed %q, want the stored 202", res.StatusCode, res.Header.Get("Idempotent-Replayed"))
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/tim3-p/go-ya-diplom/internal/problem"
+	"github.com/tim3-p/go-ya-diplom/internal/validation"
+)
+
+// Body size limits of the routes.
+const (
+	maxJSONBodySize  = 64 << 10
+	maxOrderBodySize = 1 << 10
+	maxBatchBodySize = 1 << 20
+)
+
+const (
+	contentTypeJSON = "application/json"
+	contentTypeText = "text/plain"
+)
+
+type registerRequest struct {
+	Login    string `json:"login" validate:"required,min=3,max=64,charset=login"`
+	Password string `json:"password" validate:"required,min=8,max=72,password"`
+}
+
+// loginRequest does not check the password strength, accounts created before the rules were added
+// must still be able to log in.
+type loginRequest struct {
+	Login    string `json:"login" validate:"required,max=64"`
+	Password string `json:"password" validate:"required,max=72"`
+}
+
+type withdrawRequest struct {
+	Order string  `json:"order" validate:"required"`
+	Sum   float64 `json:"sum" validate:"gt=0"`
+}
+
+type webhookRequest struct {
+	URL    string   `json:"url" validate:"required,max=2048"`
+	Secret string   `json:"secret" validate:"max=256"`
+	Events []string `json:"events"`
+}
+
+// checkContentType returns the media type of the request when it is one of allowed.
+func checkContentType(r *http.Request, allowed ...string) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil {
+		for _, contentType := range allowed {
+			if mediaType == contentType {
+				return mediaType, nil
+			}
+		}
+	}
+
+	return "", problem.New(problem.UnsupportedMediaType, "content type must be "+strings.Join(allowed, " or "), err)
+}
+
+// readBody reads at most limit bytes of the body.
+func readBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		if int64(len(body)) >= limit {
+			return nil, problem.New(problem.TooLarge, fmt.Sprintf("request body must not exceed %d bytes", limit), err)
+		}
+		return nil, problem.New(problem.BadRequest, "could not read request body", err)
+	}
+
+	return body, nil
+}
+
+// decodeJSON strictly decodes the JSON body into v and validates it.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if _, err := checkContentType(r, contentTypeJSON); err != nil {
+		return err
+	}
+
+	body, err := readBody(w, r, maxJSONBodySize)
+	if err != nil {
+		return err
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return problem.New(problem.BadRequest, "request body is empty", nil)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return problem.New(problem.BadRequest, jsonErrorDetail(err), err)
+	}
+	if decoder.More() {
+		return problem.New(problem.BadRequest, "request body must contain a single JSON object", nil)
+	}
+
+	return validate(v)
+}
+
+// validate checks the validate tags of v.
+func validate(v interface{}) error {
+	err := validation.Struct(v)
+	if err == nil {
+		return nil
+	}
+
+	var errs validation.Errors
+	if !errors.As(err, &errs) {
+		return err
+	}
+
+	fields := make([]problem.FieldError, len(errs))
+	for i, e := range errs {
+		fields[i] = problem.FieldError{Field: e.Field, Message: e.Message}
+	}
+
+	validationErr := problem.New(problem.Validation, errs.Error(), err)
+	validationErr.Fields = fields
+	return validationErr
+}
+
+func jsonErrorDetail(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("field %s must be %s", typeErr.Field, typeErr.Type)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return "unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field ")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "malformed JSON"
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/tim3-p/go-ya-diplom/internal/problem"
+	"github.com/tim3-p/go-ya-diplom/internal/service"
+	"github.com/tim3-p/go-ya-diplom/internal/storage"
+)
+
+// knownErrors maps storage and service errors to the problem catalogue.
+// Errors missing here are reported to clients as internal errors.
+var knownErrors = []struct {
+	err    error
+	kind   problem.Kind
+	detail string
+}{
+	{sql.ErrNoRows, problem.NotFound, "not found"},
+	{storage.ErrInsufficientBalance, problem.InsufficientFunds, "insufficient balance"},
+	{storage.ErrLoginTaken, problem.Conflict, "login has already been taken"},
+	{storage.ErrOrderExists, problem.Conflict, "order has already been uploaded"},
+	{storage.ErrConflict, problem.Conflict, "resource already exists"},
+	{storage.ErrReferenceNotFound, problem.NotFound, "referenced resource does not exist"},
+	{storage.ErrConstraint, problem.Validation, "invalid value"},
+	{service.ErrEmptyOrderNumber, problem.Validation, "empty order number"},
+	{service.ErrOrderNumberDigits, problem.Validation, "order number must contain only digits"},
+	{service.ErrInvalidOrderNumber, problem.Validation, "invalid order number"},
+}
+
+func mapError(err error) error {
+	var e *problem.Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	for _, known := range knownErrors {
+		if errors.Is(err, known.err) {
+			return problem.New(known.kind, known.detail, err)
+		}
+	}
+
+	return problem.New(problem.Internal, "", err)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	problem.Write(w, r, mapError(err))
+}
+
+// writeAuthError answers 401 when getAuthUser finds no user in the request context.
+func writeAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	problem.Write(w, r, problem.New(problem.Unauthorized, "unauthorized", err))
+}
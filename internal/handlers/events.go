@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tim3-p/go-ya-diplom/internal/models"
+	"github.com/tim3-p/go-ya-diplom/internal/problem"
+)
+
+const eventsHeartbeatInterval = 15 * time.Second
+
+func (h *Handler) OrderEvents(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getAuthUser(r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, problem.New(problem.Internal, "streaming is not supported", nil))
+		return
+	}
+
+	var lastEventID uint64
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		lastEventID, err = strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			writeError(w, r, problem.New(problem.BadRequest, "invalid Last-Event-ID", nil))
+			return
+		}
+	}
+
+	backlog, events, cancel := h.events.Subscribe(user.ID, lastEventID)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for _, event := range backlog {
+		if err := writeEvent(w, event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event models.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
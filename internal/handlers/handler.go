@@ -4,31 +4,22 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"io"
+	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/go-chi/chi"
+	"github.com/tim3-p/go-ya-diplom/internal/metrics"
 	"github.com/tim3-p/go-ya-diplom/internal/models"
+	"github.com/tim3-p/go-ya-diplom/internal/problem"
 	"github.com/tim3-p/go-ya-diplom/internal/service"
 	"github.com/tim3-p/go-ya-diplom/internal/storage"
 )
 
 func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
-	b, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	credentials := models.Credentials{}
-	if err := json.Unmarshal(b, &credentials); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	_, err = h.user.GetByLogin(r.Context(), credentials.Login)
-	if err == nil {
-		http.Error(w, "login has already been taken", http.StatusConflict)
+	credentials := registerRequest{}
+	if err := decodeJSON(w, r, &credentials); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -37,46 +28,51 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		PasswordHash: service.Hash(credentials.Password),
 	}
 
-	err = h.user.Create(r.Context(), newUser)
+	err := h.user.Create(r.Context(), newUser)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+	metrics.Registrations.Inc()
+
 	err = h.cookieAuthenticator.SetCookie(w, credentials.Login)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
-	b, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	credentials := models.Credentials{}
-	if err := json.Unmarshal(b, &credentials); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	credentials := loginRequest{}
+	if err := decodeJSON(w, r, &credentials); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	user, err := h.user.GetByLogin(r.Context(), credentials.Login)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, problem.New(problem.Unauthorized, "invalid login or password", nil))
+			return
+		}
+		writeError(w, r, err)
 		return
 	}
 
 	if service.Hash(credentials.Password) != user.PasswordHash {
-		http.Error(w, "invalid password", http.StatusUnauthorized)
+		writeError(w, r, problem.New(problem.Unauthorized, "invalid login or password", nil))
+		return
+	}
+
+	if user.Locked {
+		writeError(w, r, problem.New(problem.Forbidden, "account is locked", nil))
 		return
 	}
 
 	err = h.cookieAuthenticator.SetCookie(w, credentials.Login)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -86,20 +82,25 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	user, err := h.getAuthUser(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeAuthError(w, r, err)
+		return
+	}
+
+	if _, err := checkContentType(r, contentTypeText); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	b, err := io.ReadAll(r.Body)
+	b, err := readBody(w, r, maxOrderBodySize)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	number := string(b)
-	err = service.CheckOrderNumber(number)
+	number := service.NormalizeOrderNumber(string(b))
+	err = h.orderValidator.Validate(number)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		writeError(w, r, problem.New(problem.Validation, err.Error(), err))
 		return
 	}
 
@@ -110,53 +111,125 @@ func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 		UserID:    user.ID,
 	}
 
-	order, err := h.order.GetByNumber(r.Context(), number)
-	if err != nil {
-		if errors.As(err, &sql.ErrNoRows) {
-			err = h.order.Create(r.Context(), newOrder)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+	err = h.order.Create(r.Context(), newOrder)
+	if err == nil {
+		h.pointAccrualService.Accrue(r.Context(), newOrder.Number)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
 
-			h.pointAccrualService.Accrue(newOrder.Number)
+	if !errors.Is(err, storage.ErrOrderExists) {
+		writeError(w, r, err)
+		return
+	}
 
-			w.WriteHeader(http.StatusAccepted)
-			return
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	order, err := h.order.GetByNumber(r.Context(), number)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
 	if order.UserID != user.ID {
-		w.WriteHeader(http.StatusConflict)
+		writeError(w, r, problem.New(problem.Conflict, "order has been uploaded by another user", nil))
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
-func (h *Handler) GetOrders(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) CreateOrders(w http.ResponseWriter, r *http.Request) {
 	user, err := h.getAuthUser(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeAuthError(w, r, err)
 		return
 	}
 
-	orders, err := h.order.GetByUserID(r.Context(), user.ID)
+	contentType, err := checkContentType(r, contentTypeJSON, contentTypeText)
 	if err != nil {
-		if errors.As(err, &sql.ErrNoRows) {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+
+	b, err := readBody(w, r, maxBatchBodySize)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	numbers, err := parseOrderNumbers(contentType, b)
+	if err != nil {
+		writeError(w, r, problem.New(problem.BadRequest, err.Error(), err))
+		return
+	}
+
+	if len(numbers) == 0 {
+		writeError(w, r, problem.New(problem.BadRequest, "no order numbers", nil))
+		return
+	}
+
+	if len(numbers) > h.batchOrderLimit {
+		writeError(w, r, problem.New(problem.TooLarge, fmt.Sprintf("too many order numbers, the limit is %d", h.batchOrderLimit), nil))
+		return
+	}
+
+	results := make([]models.BatchOrderResult, len(numbers))
+	valid := make([]string, 0, len(numbers))
+	for i, number := range numbers {
+		results[i].Number = number
+		if err := h.orderValidator.Validate(number); err != nil {
+			results[i].Result = models.BatchInvalid
+			results[i].Error = err.Error()
+			continue
+		}
+		valid = append(valid, number)
+	}
+
+	var created []models.BatchOrderResult
+	if len(valid) > 0 {
+		created, err = h.order.CreateBatch(r.Context(), user.ID, valid, time.Now())
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
 	}
 
+	accepted := 0
+	for i := range results {
+		if results[i].Result == models.BatchInvalid {
+			continue
+		}
+		results[i] = created[0]
+		created = created[1:]
+
+		if results[i].Result == models.BatchAccepted {
+			h.pointAccrualService.Accrue(r.Context(), results[i].Number)
+			accepted++
+		}
+	}
+
+	res, err := json.Marshal(results)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if accepted > 0 {
+		w.WriteHeader(http.StatusAccepted)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.Write(res)
+}
+
+func (h *Handler) GetOrders(w http.ResponseWriter, r *http.Request) {
+	orders, ok := h.listOrders(w, r)
+	if !ok {
+		return
+	}
+
 	res, err := json.Marshal(orders)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -165,16 +238,51 @@ func (h *Handler) GetOrders(w http.ResponseWriter, r *http.Request) {
 	w.Write(res)
 }
 
+// listOrders returns the requested page of orders. When it returns false the response
+// has already been written.
+func (h *Handler) listOrders(w http.ResponseWriter, r *http.Request) ([]models.Order, bool) {
+	user, err := h.getAuthUser(r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return nil, false
+	}
+
+	filter, err := parseListFilter(r, true)
+	if err != nil {
+		writeError(w, r, problem.New(problem.BadRequest, err.Error(), err))
+		return nil, false
+	}
+
+	orders, err := h.order.List(r.Context(), user.ID, pageFilter(filter))
+	if err != nil {
+		writeError(w, r, err)
+		return nil, false
+	}
+
+	if len(orders) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil, false
+	}
+
+	if filter.Limit > 0 && len(orders) > filter.Limit {
+		orders = orders[:filter.Limit]
+		last := orders[len(orders)-1]
+		setNextCursor(w, r, models.Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	return orders, true
+}
+
 func (h *Handler) GetBalance(w http.ResponseWriter, r *http.Request) {
 	user, err := h.getAuthUser(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeAuthError(w, r, err)
 		return
 	}
 
 	res, err := json.Marshal(user)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -184,26 +292,14 @@ func (h *Handler) GetBalance(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetWithdrawals(w http.ResponseWriter, r *http.Request) {
-	user, err := h.getAuthUser(r)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+	withdrawals, ok := h.listWithdrawals(w, r)
+	if !ok {
 		return
 	}
 
-	withdrawals, err := h.withdrawal.GetByUserID(r.Context(), user.ID)
-	if err != nil {
-		if errors.As(err, &sql.ErrNoRows) {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	}
-
 	res, err := json.Marshal(withdrawals)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -212,44 +308,145 @@ func (h *Handler) GetWithdrawals(w http.ResponseWriter, r *http.Request) {
 	w.Write(res)
 }
 
+// listWithdrawals returns the requested page of withdrawals. When it returns false the response
+// has already been written.
+func (h *Handler) listWithdrawals(w http.ResponseWriter, r *http.Request) ([]models.Withdrawal, bool) {
+	user, err := h.getAuthUser(r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return nil, false
+	}
+
+	filter, err := parseListFilter(r, false)
+	if err != nil {
+		writeError(w, r, problem.New(problem.BadRequest, err.Error(), err))
+		return nil, false
+	}
+
+	withdrawals, err := h.withdrawal.List(r.Context(), user.ID, pageFilter(filter))
+	if err != nil {
+		writeError(w, r, err)
+		return nil, false
+	}
+
+	if len(withdrawals) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil, false
+	}
+
+	if filter.Limit > 0 && len(withdrawals) > filter.Limit {
+		withdrawals = withdrawals[:filter.Limit]
+		last := withdrawals[len(withdrawals)-1]
+		setNextCursor(w, r, models.Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	return withdrawals, true
+}
+
 func (h *Handler) Withdraw(w http.ResponseWriter, r *http.Request) {
 	user, err := h.getAuthUser(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		writeAuthError(w, r, err)
 		return
 	}
 
-	b, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	request := withdrawRequest{}
+	if err := decodeJSON(w, r, &request); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	withdrawal := &models.Withdrawal{
+		Order:     service.NormalizeOrderNumber(request.Order),
+		Sum:       request.Sum,
 		CreatedAt: time.Now(),
 		UserID:    user.ID,
 	}
-	err = json.Unmarshal(b, &withdrawal)
+	err = h.orderValidator.Validate(withdrawal.Order)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, problem.New(problem.Validation, err.Error(), err))
 		return
 	}
 
-	err = service.CheckOrderNumber(withdrawal.Order)
+	err = h.withdrawal.Create(r.Context(), *withdrawal)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		writeError(w, r, err)
 		return
 	}
+	metrics.PointsWithdrawn.Add(withdrawal.Sum)
 
-	err = h.withdrawal.Create(r.Context(), *withdrawal)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) GetBalanceHistory(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getAuthUser(r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+
+	filter, err := parseStatementFilter(r)
+	if err != nil {
+		writeError(w, r, problem.New(problem.BadRequest, err.Error(), err))
+		return
+	}
+
+	// One extra entry is requested to find out whether there is a next page.
+	page := filter
+	page.Limit++
+	entries, err := h.user.GetStatement(r.Context(), user.ID, page)
 	if err != nil {
-		if errors.As(err, &storage.ErrInsufficientBalance) {
-			http.Error(w, "insufficient balance", http.StatusPaymentRequired)
+		writeError(w, r, err)
+		return
+	}
+
+	statement := models.Statement{Entries: entries}
+	if len(entries) > filter.Limit {
+		statement.Entries = entries[:filter.Limit]
+		last := statement.Entries[filter.Limit-1]
+		statement.NextCursor = models.StatementCursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	}
+
+	res, err := json.Marshal(statement)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func (h *Handler) GetOrder(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getAuthUser(r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+
+	details, err := h.order.GetDetails(r.Context(), chi.URLParam(r, "number"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, problem.New(problem.NotFound, "order not found", nil))
 			return
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
+	if details.Order.UserID != user.ID {
+		writeError(w, r, problem.New(problem.Forbidden, "order belongs to another user", nil))
+		return
+	}
+
+	res, err := json.Marshal(details)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	w.Write(res)
 }
@@ -0,0 +1,493 @@
+// Package handlerstest runs the API handler on in-memory repositories, for tests of the handlers
+// and of the clients of the API.
+package handlerstest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tim3-p/go-ya-diplom/internal/handlers"
+	"github.com/tim3-p/go-ya-diplom/internal/interfaces"
+	middleware "github.com/tim3-p/go-ya-diplom/internal/middlewares"
+	"github.com/tim3-p/go-ya-diplom/internal/models"
+	"github.com/tim3-p/go-ya-diplom/internal/service"
+	"github.com/tim3-p/go-ya-diplom/internal/storage"
+)
+
+// CompressMinSize is the smallest response compressed by the handler of NewHandler.
+const CompressMinSize = 64
+
+// Store keeps the users, orders, withdrawals and webhooks of the handler in memory.
+type Store struct {
+	mu          sync.Mutex
+	lastID      uint64
+	users       map[string]*models.User
+	orders      []*models.Order
+	history     map[uint64][]models.OrderStatusChange
+	withdrawals []models.Withdrawal
+	webhooks    []models.Webhook
+	responses   map[string]models.IdempotentResponse
+}
+
+func NewStore() *Store {
+	return &Store{
+		users:     map[string]*models.User{},
+		history:   map[uint64][]models.OrderStatusChange{},
+		responses: map[string]models.IdempotentResponse{},
+	}
+}
+
+// NewHandler returns the handler wired like serve does, without rate limits.
+func NewHandler(store *Store) *handlers.Handler {
+	cookieAuthenticator := service.NewCookieAuthenticator([]byte("test key"))
+	users := userRepository{store}
+	noLimit := middleware.NewRateLimiter("test", middleware.RateLimitPolicy{}, nil)
+
+	return handlers.NewHandler(
+		"",
+		10,
+		users,
+		orderRepository{store},
+		withdrawalRepository{store},
+		webhookRepository{store},
+		cookieAuthenticator,
+		accrualService{},
+		service.NewOrderNumberValidator(1, 255, service.AllowedPrefixes{}),
+		service.NewEventBus(100),
+		health{},
+		middleware.NewAuthenticator(cookieAuthenticator, users),
+		middleware.NewIdempotency(idempotencyStore{store}),
+		handlers.RateLimits{Auth: noLimit, Orders: noLimit, Default: noLimit},
+		[]interfaces.Middleware{
+			middleware.NewCompressor(CompressMinSize, middleware.DefaultCompressibleTypes),
+			middleware.Decompressor{},
+		},
+	)
+}
+
+// Process sets the final status of the order as the accrual worker does and credits the accrual.
+func (s *Store) Process(number string, accrual float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, order := range s.orders {
+		if order.Number != number {
+			continue
+		}
+
+		order.Status, order.Accrual = models.Processed, accrual
+		s.history[order.ID] = append(s.history[order.ID], models.OrderStatusChange{Status: models.Processed, Accrual: accrual, CreatedAt: time.Now()})
+		for _, user := range s.users {
+			if user.ID == order.UserID {
+				user.Balance += accrual
+			}
+		}
+	}
+}
+
+// Lock locks the account as gophermartctl does.
+func (s *Store) Lock(login string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[login]; ok {
+		user.Locked = true
+	}
+}
+
+func (s *Store) nextID() uint64 {
+	s.lastID++
+	return s.lastID
+}
+
+type userRepository struct{ *Store }
+
+func (r userRepository) Create(ctx context.Context, user models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[user.Login]; ok {
+		return storage.ErrLoginTaken
+	}
+	user.ID = r.nextID()
+	r.users[user.Login] = &user
+	return nil
+}
+
+func (r userRepository) GetByLogin(ctx context.Context, login string) (models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[login]
+	if !ok {
+		return models.User{}, sql.ErrNoRows
+	}
+	return *user, nil
+}
+
+// GetStatement mirrors the statement query of the storage over the processed orders and the withdrawals.
+func (r userRepository) GetStatement(ctx context.Context, userID uint64, filter models.StatementFilter) ([]models.StatementEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var entries []models.StatementEntry
+	for _, order := range r.orders {
+		if order.UserID != userID || order.Status != models.Processed || order.Accrual == 0 {
+			continue
+		}
+
+		entry := models.StatementEntry{ID: fmt.Sprintf("o%d", order.ID), Type: models.Credit, Order: order.Number, Amount: order.Accrual, CreatedAt: order.CreatedAt}
+		for _, change := range r.history[order.ID] {
+			if change.Status == models.Processed {
+				entry.CreatedAt = change.CreatedAt
+				break
+			}
+		}
+		entries = append(entries, entry)
+	}
+	for _, withdrawal := range r.withdrawals {
+		if withdrawal.UserID == userID {
+			entries = append(entries, models.StatementEntry{ID: fmt.Sprintf("w%d", withdrawal.ID), Type: models.Debit, Order: withdrawal.Order, Amount: withdrawal.Sum, CreatedAt: withdrawal.CreatedAt})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].CreatedAt.Equal(entries[j].CreatedAt) {
+			return entries[i].ID < entries[j].ID
+		}
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+
+	result := []models.StatementEntry{}
+	var balance float64
+	for _, entry := range entries {
+		if entry.Type == models.Debit {
+			balance -= entry.Amount
+		} else {
+			balance += entry.Amount
+		}
+		entry.Balance = balance
+
+		if !filter.From.IsZero() && entry.CreatedAt.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !entry.CreatedAt.Before(filter.To) {
+			continue
+		}
+		if after := filter.After; after != nil && (entry.CreatedAt.Before(after.CreatedAt) || entry.CreatedAt.Equal(after.CreatedAt) && entry.ID <= after.ID) {
+			continue
+		}
+		if filter.Limit > 0 && len(result) == filter.Limit {
+			break
+		}
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+type orderRepository struct{ *Store }
+
+func (r orderRepository) Create(ctx context.Context, order models.Order) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.create(order)
+}
+
+func (r orderRepository) create(order models.Order) error {
+	for _, existing := range r.orders {
+		if existing.Number == order.Number {
+			return storage.ErrOrderExists
+		}
+	}
+
+	order.ID = r.nextID()
+	r.orders = append(r.orders, &order)
+	r.history[order.ID] = []models.OrderStatusChange{{Status: order.Status, CreatedAt: order.CreatedAt}}
+	return nil
+}
+
+func (r orderRepository) CreateBatch(ctx context.Context, userID uint64, numbers []string, createdAt time.Time) ([]models.BatchOrderResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	results := make([]models.BatchOrderResult, 0, len(numbers))
+	for _, number := range numbers {
+		result := models.BatchAccepted
+		if err := r.create(models.Order{Number: number, Status: models.New, CreatedAt: createdAt, UserID: userID}); err != nil {
+			owner, _ := r.find(number)
+			result = models.BatchAlreadyUploaded
+			if owner.UserID != userID {
+				result = models.BatchConflict
+			}
+		}
+		results = append(results, models.BatchOrderResult{Number: number, Result: result})
+	}
+	return results, nil
+}
+
+func (r orderRepository) find(number string) (models.Order, bool) {
+	for _, order := range r.orders {
+		if order.Number == number {
+			return *order, true
+		}
+	}
+	return models.Order{}, false
+}
+
+func (r orderRepository) GetByUserID(ctx context.Context, userID uint64) ([]models.Order, error) {
+	orders, _ := r.List(ctx, userID, models.ListFilter{})
+	if len(orders) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return orders, nil
+}
+
+func (r orderRepository) List(ctx context.Context, userID uint64, filter models.ListFilter) ([]models.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var orders []models.Order
+	for _, order := range r.orders {
+		if order.UserID == userID && matchStatus(order.Status, filter.Statuses) {
+			orders = append(orders, *order)
+		}
+	}
+
+	cursors := make([]models.Cursor, len(orders))
+	for i, order := range orders {
+		cursors[i] = models.Cursor{CreatedAt: order.CreatedAt, ID: order.ID}
+	}
+
+	result := []models.Order{}
+	for _, i := range page(cursors, filter) {
+		result = append(result, orders[i])
+	}
+	return result, nil
+}
+
+func (r orderRepository) GetByNumber(ctx context.Context, number string) (models.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	order, ok := r.find(number)
+	if !ok {
+		return models.Order{}, sql.ErrNoRows
+	}
+	return order, nil
+}
+
+func (r orderRepository) GetDetails(ctx context.Context, number string) (models.OrderDetails, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	order, ok := r.find(number)
+	if !ok {
+		return models.OrderDetails{}, sql.ErrNoRows
+	}
+	return models.OrderDetails{Order: order, History: append([]models.OrderStatusChange{}, r.history[order.ID]...)}, nil
+}
+
+type withdrawalRepository struct{ *Store }
+
+func (r withdrawalRepository) Create(ctx context.Context, withdrawal models.Withdrawal) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if user.ID != withdrawal.UserID {
+			continue
+		}
+		if user.Balance < withdrawal.Sum {
+			return storage.ErrInsufficientBalance
+		}
+		user.Balance -= withdrawal.Sum
+		user.Withdrawn += withdrawal.Sum
+	}
+
+	withdrawal.ID = r.nextID()
+	r.withdrawals = append(r.withdrawals, withdrawal)
+	return nil
+}
+
+func (r withdrawalRepository) GetByUserID(ctx context.Context, userID uint64) ([]models.Withdrawal, error) {
+	withdrawals, _ := r.List(ctx, userID, models.ListFilter{})
+	if len(withdrawals) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return withdrawals, nil
+}
+
+func (r withdrawalRepository) List(ctx context.Context, userID uint64, filter models.ListFilter) ([]models.Withdrawal, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var withdrawals []models.Withdrawal
+	for _, withdrawal := range r.withdrawals {
+		if withdrawal.UserID == userID {
+			withdrawals = append(withdrawals, withdrawal)
+		}
+	}
+
+	cursors := make([]models.Cursor, len(withdrawals))
+	for i, withdrawal := range withdrawals {
+		cursors[i] = models.Cursor{CreatedAt: withdrawal.CreatedAt, ID: withdrawal.ID}
+	}
+
+	result := []models.Withdrawal{}
+	for _, i := range page(cursors, filter) {
+		result = append(result, withdrawals[i])
+	}
+	return result, nil
+}
+
+type webhookRepository struct{ *Store }
+
+func (r webhookRepository) Create(ctx context.Context, webhook models.Webhook) (models.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	webhook.ID = r.nextID()
+	r.webhooks = append(r.webhooks, webhook)
+	return webhook, nil
+}
+
+func (r webhookRepository) GetByUserID(ctx context.Context, userID uint64) ([]models.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	webhooks := []models.Webhook{}
+	for _, webhook := range r.webhooks {
+		if webhook.UserID == userID {
+			webhook.Secret = ""
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks, nil
+}
+
+func (r webhookRepository) Delete(ctx context.Context, userID, webhookID uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, webhook := range r.webhooks {
+		if webhook.ID == webhookID && webhook.UserID == userID {
+			r.webhooks = append(r.webhooks[:i], r.webhooks[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (r webhookRepository) GetDeliveries(ctx context.Context, userID, webhookID uint64) ([]models.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, webhook := range r.webhooks {
+		if webhook.ID == webhookID && webhook.UserID == userID {
+			return []models.WebhookDelivery{}, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r webhookRepository) Redeliver(ctx context.Context, userID, webhookID, deliveryID uint64) error {
+	return sql.ErrNoRows
+}
+
+type idempotencyStore struct{ *Store }
+
+func (r idempotencyStore) Reserve(ctx context.Context, login, key, fingerprint string, lease time.Duration) (models.IdempotentResponse, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if response, ok := r.responses[login+"\x00"+key]; ok {
+		return response, true, nil
+	}
+	r.responses[login+"\x00"+key] = models.IdempotentResponse{Fingerprint: fingerprint}
+	return models.IdempotentResponse{}, false, nil
+}
+
+func (r idempotencyStore) Save(ctx context.Context, login, key string, response models.IdempotentResponse) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.responses[login+"\x00"+key] = response
+	return nil
+}
+
+func (r idempotencyStore) Release(ctx context.Context, login, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.responses, login+"\x00"+key)
+	return nil
+}
+
+func (r idempotencyStore) Cleanup(ctx context.Context, before time.Time) error {
+	return nil
+}
+
+type accrualService struct{}
+
+func (accrualService) Accrue(ctx context.Context, order string) {}
+
+type health struct{}
+
+func (health) Ready(ctx context.Context) models.Readiness {
+	return models.Readiness{Ready: true, Checks: []models.Check{{Name: "database", Healthy: true}}}
+}
+
+func (h health) Status(ctx context.Context) models.SystemStatus {
+	return models.SystemStatus{Readiness: h.Ready(ctx), StartedAt: time.Now(), Uptime: "0s"}
+}
+
+func matchStatus(status string, statuses []string) bool {
+	if len(statuses) == 0 {
+		return true
+	}
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// page applies the filter like the keyset pagination of the storage package and returns
+// the indexes of the selected items in the list order.
+func page(cursors []models.Cursor, filter models.ListFilter) []int {
+	indexes := make([]int, len(cursors))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		a, b := cursors[indexes[i]], cursors[indexes[j]]
+		if a.CreatedAt.Equal(b.CreatedAt) {
+			return a.ID < b.ID
+		}
+		return a.CreatedAt.Before(b.CreatedAt)
+	})
+
+	var result []int
+	for _, i := range indexes {
+		c := cursors[i]
+		if !filter.From.IsZero() && c.CreatedAt.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !c.CreatedAt.Before(filter.To) {
+			continue
+		}
+		if after := filter.After; after != nil && (c.CreatedAt.Before(after.CreatedAt) || c.CreatedAt.Equal(after.CreatedAt) && c.ID <= after.ID) {
+			continue
+		}
+		if filter.Limit > 0 && len(result) == filter.Limit {
+			break
+		}
+		result = append(result, i)
+	}
+	return result
+}
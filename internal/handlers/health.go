@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/tim3-p/go-ya-diplom/internal/interfaces"
+)
+
+// NewProbes serves only the probes, for processes without the API.
+func NewProbes(health interfaces.HealthChecker) *Handler {
+	h := &Handler{
+		Mux:    chi.NewMux(),
+		health: health,
+	}
+
+	h.Get("/healthz", h.Healthz)
+	h.Get("/readyz", h.Readyz)
+	h.Get("/status", h.Status)
+
+	return h
+}
+
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	readiness := h.health.Ready(r.Context())
+
+	res, err := json.Marshal(readiness)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if readiness.Ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(res)
+}
+
+func (h *Handler) Status(w http.ResponseWriter, r *http.Request) {
+	res, err := json.Marshal(h.health.Status(r.Context()))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
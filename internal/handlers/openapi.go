@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+func (h *Handler) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPISpec)
+}
+
+// CheckOpenAPI compares the routes of the router with the operations of the OpenAPI document
+// and returns an error listing the routes missing on either side.
+func CheckOpenAPI(routes chi.Routes) error {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		return fmt.Errorf("invalid openapi document: %w", err)
+	}
+
+	documented := map[string]bool{}
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	var undocumented []string
+	err := chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		key := method + " " + route
+		if documented[key] {
+			delete(documented, key)
+		} else {
+			undocumented = append(undocumented, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	if len(undocumented) > 0 {
+		sort.Strings(undocumented)
+		problems = append(problems, "undocumented routes: "+strings.Join(undocumented, ", "))
+	}
+	if len(documented) > 0 {
+		var missing []string
+		for key := range documented {
+			missing = append(missing, key)
+		}
+		sort.Strings(missing)
+		problems = append(problems, "documented routes that are not registered: "+strings.Join(missing, ", "))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("openapi document is out of sync: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
@@ -1,8 +1,14 @@
 package handlers
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/tim3-p/go-ya-diplom/internal/interfaces"
@@ -13,42 +19,98 @@ import (
 type Handler struct {
 	*chi.Mux
 	baseURL             string
+	batchOrderLimit     int
 	user                interfaces.User
 	order               interfaces.Order
 	withdrawal          interfaces.Withdrawal
+	webhook             interfaces.Webhook
 	cookieAuthenticator interfaces.CookieAuthenticator
 	pointAccrualService interfaces.PointAccrualService
+	orderValidator      interfaces.OrderNumberValidator
+	events              interfaces.EventSubscriber
+	health              interfaces.HealthChecker
 	authenticator       interfaces.Middleware
 }
 
+// RateLimits holds the rate limiting middlewares of the route groups.
+type RateLimits struct {
+	Auth    interfaces.Middleware
+	Orders  interfaces.Middleware
+	Default interfaces.Middleware
+}
+
 func NewHandler(
 	baseURL string,
+	batchOrderLimit int,
 	user interfaces.User,
 	order interfaces.Order,
 	withdrawal interfaces.Withdrawal,
+	webhook interfaces.Webhook,
 	cookieAuthenticator interfaces.CookieAuthenticator,
 	pointAccrualService interfaces.PointAccrualService,
+	orderValidator interfaces.OrderNumberValidator,
+	events interfaces.EventSubscriber,
+	health interfaces.HealthChecker,
 	authenticator interfaces.Middleware,
+	idempotency interfaces.Middleware,
+	rateLimits RateLimits,
 	middlewares []interfaces.Middleware,
 ) *Handler {
 	h := &Handler{
 		Mux:                 chi.NewMux(),
 		baseURL:             baseURL,
+		batchOrderLimit:     batchOrderLimit,
 		user:                user,
 		order:               order,
 		withdrawal:          withdrawal,
+		webhook:             webhook,
 		cookieAuthenticator: cookieAuthenticator,
 		pointAccrualService: pointAccrualService,
+		orderValidator:      orderValidator,
+		events:              events,
+		health:              health,
 	}
 
-	h.Post("/api/user/register", Middlewares(h.Register, middlewares))
-	h.Post("/api/user/login", Middlewares(h.Login, middlewares))
+	// Probes skip authentication and compression.
+	h.Get("/healthz", h.Healthz)
+	h.Get("/readyz", h.Readyz)
+	h.Get("/status", h.Status)
+
+	// The API description, keep openapi.json in sync with the routes below.
+	h.Get("/api/openapi.json", h.OpenAPI)
+
+	// Unauthenticated routes are limited by IP, the others by login.
+	h.Post("/api/user/register", deprecated("/api/v2/user/register", rateLimits.Auth.Handle(Middlewares(h.Register, middlewares))))
+	h.Post("/api/user/login", deprecated("/api/v2/user/login", rateLimits.Auth.Handle(Middlewares(h.Login, middlewares))))
+
+	orders := rateLimits.Orders.Handle
+	limited := rateLimits.Default.Handle
+
+	h.Post("/api/user/orders", deprecated("/api/v2/user/orders", authenticator.Handle(orders(Middlewares(idempotency.Handle(h.CreateOrder), middlewares)))))
+	h.Post("/api/user/orders/batch", authenticator.Handle(orders(Middlewares(idempotency.Handle(h.CreateOrders), middlewares))))
+	h.Get("/api/user/orders", deprecated("/api/v2/user/orders", authenticator.Handle(limited(Middlewares(h.GetOrders, middlewares)))))
+	// The event stream is not compressed, responses must be flushed after every event.
+	h.Get("/api/user/orders/events", authenticator.Handle(limited(h.OrderEvents)))
+	h.Get("/api/user/orders/{number}", authenticator.Handle(limited(Middlewares(h.GetOrder, middlewares))))
+	h.Get("/api/user/balance", deprecated("/api/v2/user/balance", authenticator.Handle(limited(Middlewares(h.GetBalance, middlewares)))))
+	h.Get("/api/user/balance/history", authenticator.Handle(limited(Middlewares(h.GetBalanceHistory, middlewares))))
+	h.Post("/api/user/balance/withdraw", deprecated("/api/v2/user/balance/withdraw", authenticator.Handle(limited(Middlewares(idempotency.Handle(h.Withdraw), middlewares)))))
+	h.Get("/api/user/balance/withdrawals", deprecated("/api/v2/user/balance/withdrawals", authenticator.Handle(limited(Middlewares(h.GetWithdrawals, middlewares)))))
+
+	h.Post("/api/user/webhooks", authenticator.Handle(limited(Middlewares(h.CreateWebhook, middlewares))))
+	h.Get("/api/user/webhooks", authenticator.Handle(limited(Middlewares(h.GetWebhooks, middlewares))))
+	h.Delete("/api/user/webhooks/{id}", authenticator.Handle(limited(Middlewares(h.DeleteWebhook, middlewares))))
+	h.Get("/api/user/webhooks/{id}/deliveries", authenticator.Handle(limited(Middlewares(h.GetWebhookDeliveries, middlewares))))
+	h.Post("/api/user/webhooks/{id}/deliveries/{delivery}/redeliver", authenticator.Handle(limited(Middlewares(h.RedeliverWebhook, middlewares))))
 
-	h.Post("/api/user/orders", authenticator.Handle(Middlewares(h.CreateOrder, middlewares)))
-	h.Get("/api/user/orders", authenticator.Handle(Middlewares(h.GetOrders, middlewares)))
-	h.Get("/api/user/balance", authenticator.Handle(Middlewares(h.GetBalance, middlewares)))
-	h.Post("/api/user/balance/withdraw", authenticator.Handle(Middlewares(h.Withdraw, middlewares)))
-	h.Get("/api/user/balance/withdrawals", authenticator.Handle(Middlewares(h.GetWithdrawals, middlewares)))
+	// v2 follows the schema of the specification, v1 responses stay as they were.
+	h.Post("/api/v2/user/register", rateLimits.Auth.Handle(Middlewares(h.Register, middlewares)))
+	h.Post("/api/v2/user/login", rateLimits.Auth.Handle(Middlewares(h.Login, middlewares)))
+	h.Post("/api/v2/user/orders", authenticator.Handle(orders(Middlewares(idempotency.Handle(h.CreateOrder), middlewares))))
+	h.Get("/api/v2/user/orders", authenticator.Handle(limited(Middlewares(h.GetOrdersV2, middlewares))))
+	h.Get("/api/v2/user/balance", authenticator.Handle(limited(Middlewares(h.GetBalanceV2, middlewares))))
+	h.Post("/api/v2/user/balance/withdraw", authenticator.Handle(limited(Middlewares(idempotency.Handle(h.Withdraw), middlewares))))
+	h.Get("/api/v2/user/balance/withdrawals", authenticator.Handle(limited(Middlewares(h.GetWithdrawalsV2, middlewares))))
 
 	return h
 }
@@ -61,16 +123,160 @@ func Middlewares(handler http.HandlerFunc, middlewares []interfaces.Middleware)
 	return handler
 }
 
+// getAuthUser returns the user checked by the Authenticator middleware.
 func (h *Handler) getAuthUser(r *http.Request) (models.User, error) {
-	login, ok := service.LoginFromContext(r.Context())
+	user, ok := service.UserFromContext(r.Context())
 	if !ok {
 		return models.User{}, errors.New("unauthorized")
 	}
 
-	user, err := h.user.GetByLogin(r.Context(), login)
+	return user, nil
+}
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// pageParams holds the query parameters shared by the lists and the balance history.
+// The cursor format differs between them, so after is decoded by the callers.
+type pageParams struct {
+	limit int
+	after string
+	from  time.Time
+	to    time.Time
+}
+
+// parsePageParams reads limit, after, from and to query parameters.
+func parsePageParams(query url.Values) (pageParams, error) {
+	params := pageParams{after: query.Get("after")}
+
+	if limit := query.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value <= 0 || value > maxPageLimit {
+			return pageParams{}, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		params.limit = value
+	}
+
+	var err error
+	if from := query.Get("from"); from != "" {
+		params.from, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			return pageParams{}, errors.New("from must be in RFC3339 format")
+		}
+	}
+
+	if to := query.Get("to"); to != "" {
+		params.to, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			return pageParams{}, errors.New("to must be in RFC3339 format")
+		}
+	}
+
+	return params, nil
+}
+
+// parseStatementFilter reads the page parameters of the balance history, which is limited
+// to defaultPageLimit entries by default.
+func parseStatementFilter(r *http.Request) (models.StatementFilter, error) {
+	params, err := parsePageParams(r.URL.Query())
 	if err != nil {
-		return models.User{}, err
+		return models.StatementFilter{}, err
 	}
 
-	return user, nil
+	filter := models.StatementFilter{From: params.from, To: params.to, Limit: params.limit}
+	if filter.Limit == 0 {
+		filter.Limit = defaultPageLimit
+	}
+
+	if params.after != "" {
+		cursor, err := models.DecodeStatementCursor(params.after)
+		if err != nil {
+			return models.StatementFilter{}, errors.New("invalid cursor")
+		}
+		filter.After = &cursor
+	}
+
+	return filter, nil
+}
+
+const NextCursorHeader = "X-Next-Cursor"
+
+// parseListFilter reads the page parameters and the status filter.
+// Without limit the whole list is returned.
+func parseListFilter(r *http.Request, withStatus bool) (models.ListFilter, error) {
+	query := r.URL.Query()
+	params, err := parsePageParams(query)
+	if err != nil {
+		return models.ListFilter{}, err
+	}
+
+	filter := models.ListFilter{From: params.from, To: params.to, Limit: params.limit}
+
+	if params.after != "" {
+		cursor, err := models.DecodeCursor(params.after)
+		if err != nil {
+			return models.ListFilter{}, errors.New("invalid cursor")
+		}
+		filter.After = &cursor
+	}
+
+	if withStatus {
+		for _, value := range query["status"] {
+			for _, status := range strings.Split(value, ",") {
+				status = strings.ToUpper(strings.TrimSpace(status))
+				switch status {
+				case models.New, models.Processing, models.Invalid, models.Processed:
+					filter.Statuses = append(filter.Statuses, status)
+				default:
+					return models.ListFilter{}, fmt.Errorf("unknown status %q", status)
+				}
+			}
+		}
+	}
+
+	return filter, nil
+}
+
+// pageFilter requests one extra row to find out whether there is a next page.
+func pageFilter(filter models.ListFilter) models.ListFilter {
+	if filter.Limit > 0 {
+		filter.Limit++
+	}
+	return filter
+}
+
+func setNextCursor(w http.ResponseWriter, r *http.Request, cursor models.Cursor) {
+	next := cursor.Encode()
+
+	query := r.URL.Query()
+	query.Set("after", next)
+	link := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+
+	w.Header().Set(NextCursorHeader, next)
+	w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, link.String()))
+}
+
+// parseOrderNumbers accepts a JSON array of numbers or newline-separated text.
+func parseOrderNumbers(contentType string, body []byte) ([]string, error) {
+	var numbers []string
+
+	if contentType == contentTypeJSON {
+		if err := json.Unmarshal(body, &numbers); err != nil {
+			return nil, errors.New("body must be a JSON array of order numbers")
+		}
+	} else {
+		numbers = strings.Split(string(body), "\n")
+	}
+
+	result := make([]string, 0, len(numbers))
+	for _, number := range numbers {
+		number = service.NormalizeOrderNumber(number)
+		if number != "" {
+			result = append(result, number)
+		}
+	}
+
+	return result, nil
 }
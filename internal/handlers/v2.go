@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tim3-p/go-ya-diplom/internal/models"
+)
+
+// The v2 responses follow SPECIFICATION.md. They are built from DTOs, so that changes
+// of the models do not leak into the API.
+
+type orderV2 struct {
+	Number     string   `json:"number"`
+	Status     string   `json:"status"`
+	Accrual    *float64 `json:"accrual,omitempty"`
+	UploadedAt string   `json:"uploaded_at"`
+}
+
+type balanceV2 struct {
+	Current   float64 `json:"current"`
+	Withdrawn float64 `json:"withdrawn"`
+}
+
+type withdrawalV2 struct {
+	Order       string  `json:"order"`
+	Sum         float64 `json:"sum"`
+	ProcessedAt string  `json:"processed_at"`
+}
+
+// newOrderV2 reports the accrual of processed orders even when it is zero.
+func newOrderV2(order models.Order) orderV2 {
+	dto := orderV2{
+		Number:     order.Number,
+		Status:     order.Status,
+		UploadedAt: order.CreatedAt.Format(time.RFC3339),
+	}
+	if order.Status == models.Processed {
+		accrual := order.Accrual
+		dto.Accrual = &accrual
+	}
+
+	return dto
+}
+
+func newBalanceV2(user models.User) balanceV2 {
+	return balanceV2{
+		Current:   user.Balance,
+		Withdrawn: user.Withdrawn,
+	}
+}
+
+func newWithdrawalV2(withdrawal models.Withdrawal) withdrawalV2 {
+	return withdrawalV2{
+		Order:       withdrawal.Order,
+		Sum:         withdrawal.Sum,
+		ProcessedAt: withdrawal.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func (h *Handler) GetOrdersV2(w http.ResponseWriter, r *http.Request) {
+	orders, ok := h.listOrders(w, r)
+	if !ok {
+		return
+	}
+
+	dtos := make([]orderV2, len(orders))
+	for i, order := range orders {
+		dtos[i] = newOrderV2(order)
+	}
+
+	res, err := json.Marshal(dtos)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func (h *Handler) GetBalanceV2(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getAuthUser(r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+
+	res, err := json.Marshal(newBalanceV2(user))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func (h *Handler) GetWithdrawalsV2(w http.ResponseWriter, r *http.Request) {
+	withdrawals, ok := h.listWithdrawals(w, r)
+	if !ok {
+		return
+	}
+
+	dtos := make([]withdrawalV2, len(withdrawals))
+	for i, withdrawal := range withdrawals {
+		dtos[i] = newWithdrawalV2(withdrawal)
+	}
+
+	res, err := json.Marshal(dtos)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// deprecated marks a v1 route that has a v2 successor.
+func deprecated(successor string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+		next.ServeHTTP(w, r)
+	}
+}
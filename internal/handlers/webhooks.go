@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/tim3-p/go-ya-diplom/internal/models"
+	"github.com/tim3-p/go-ya-diplom/internal/problem"
+	"github.com/tim3-p/go-ya-diplom/internal/service"
+)
+
+var webhookEvents = []string{
+	models.WebhookOrderProcessed,
+	models.WebhookOrderInvalid,
+	models.WebhookWithdrawalCreated,
+}
+
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getAuthUser(r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+
+	request := webhookRequest{}
+	if err := decodeJSON(w, r, &request); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	webhook := models.Webhook{
+		URL:    request.URL,
+		Secret: request.Secret,
+		Events: request.Events,
+	}
+
+	if err := validateWebhook(r.Context(), &webhook); err != nil {
+		writeError(w, r, problem.New(problem.Validation, err.Error(), err))
+		return
+	}
+
+	if webhook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		webhook.Secret = hex.EncodeToString(secret)
+	}
+
+	webhook.UserID = user.ID
+	webhook.CreatedAt = time.Now()
+
+	webhook, err = h.webhook.Create(r.Context(), webhook)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// The secret is returned only once, on creation.
+	res, err := json.Marshal(webhook)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(res)
+}
+
+func (h *Handler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getAuthUser(r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+
+	webhooks, err := h.webhook.GetByUserID(r.Context(), user.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	res, err := json.Marshal(webhooks)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getAuthUser(r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+
+	webhookID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, r, problem.New(problem.BadRequest, "invalid webhook id", nil))
+		return
+	}
+
+	err = h.webhook.Delete(r.Context(), user.ID, webhookID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, problem.New(problem.NotFound, "webhook not found", nil))
+			return
+		}
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getAuthUser(r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+
+	webhookID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, r, problem.New(problem.BadRequest, "invalid webhook id", nil))
+		return
+	}
+
+	deliveries, err := h.webhook.GetDeliveries(r.Context(), user.ID, webhookID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	res, err := json.Marshal(deliveries)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func (h *Handler) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getAuthUser(r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+
+	webhookID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, r, problem.New(problem.BadRequest, "invalid webhook id", nil))
+		return
+	}
+
+	deliveryID, err := strconv.ParseUint(chi.URLParam(r, "delivery"), 10, 64)
+	if err != nil {
+		writeError(w, r, problem.New(problem.BadRequest, "invalid delivery id", nil))
+		return
+	}
+
+	err = h.webhook.Redeliver(r.Context(), user.ID, webhookID, deliveryID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, problem.New(problem.NotFound, "delivery not found", nil))
+			return
+		}
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func validateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	if err := service.ValidateWebhookURL(ctx, net.DefaultResolver, webhook.URL); err != nil {
+		return err
+	}
+
+	if len(webhook.Events) == 0 {
+		webhook.Events = webhookEvents
+		return nil
+	}
+
+	for _, event := range webhook.Events {
+		known := false
+		for _, webhookEvent := range webhookEvents {
+			if event == webhookEvent {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+
+	return nil
+}
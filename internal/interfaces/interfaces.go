@@ -3,6 +3,7 @@ package interfaces
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/tim3-p/go-ya-diplom/internal/models"
 )
@@ -10,17 +11,30 @@ import (
 type User interface {
 	Create(ctx context.Context, user models.User) error
 	GetByLogin(ctx context.Context, login string) (models.User, error)
+	GetStatement(ctx context.Context, userID uint64, filter models.StatementFilter) ([]models.StatementEntry, error)
 }
 
 type Order interface {
 	Create(ctx context.Context, order models.Order) error
+	CreateBatch(ctx context.Context, userID uint64, numbers []string, createdAt time.Time) ([]models.BatchOrderResult, error)
 	GetByUserID(ctx context.Context, userID uint64) ([]models.Order, error)
+	List(ctx context.Context, userID uint64, filter models.ListFilter) ([]models.Order, error)
 	GetByNumber(ctx context.Context, number string) (models.Order, error)
+	GetDetails(ctx context.Context, number string) (models.OrderDetails, error)
 }
 
 type Withdrawal interface {
 	Create(ctx context.Context, withdrawal models.Withdrawal) error
 	GetByUserID(ctx context.Context, userID uint64) ([]models.Withdrawal, error)
+	List(ctx context.Context, userID uint64, filter models.ListFilter) ([]models.Withdrawal, error)
+}
+
+type Webhook interface {
+	Create(ctx context.Context, webhook models.Webhook) (models.Webhook, error)
+	GetByUserID(ctx context.Context, userID uint64) ([]models.Webhook, error)
+	Delete(ctx context.Context, userID, webhookID uint64) error
+	GetDeliveries(ctx context.Context, userID, webhookID uint64) ([]models.WebhookDelivery, error)
+	Redeliver(ctx context.Context, userID, webhookID, deliveryID uint64) error
 }
 
 type CookieAuthenticator interface {
@@ -28,7 +42,20 @@ type CookieAuthenticator interface {
 }
 
 type PointAccrualService interface {
-	Accrue(order string)
+	Accrue(ctx context.Context, order string)
+}
+
+type EventSubscriber interface {
+	Subscribe(userID uint64, lastEventID uint64) ([]models.Event, <-chan models.Event, func())
+}
+
+type HealthChecker interface {
+	Ready(ctx context.Context) models.Readiness
+	Status(ctx context.Context) models.SystemStatus
+}
+
+type OrderNumberValidator interface {
+	Validate(number string) error
 }
 
 type Middleware interface {
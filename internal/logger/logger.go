@@ -0,0 +1,76 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type contextKey string
+
+const (
+	loggerKey    contextKey = "logger"
+	requestIDKey contextKey = "requestID"
+	userKey      contextKey = "user"
+)
+
+// New builds a logger writing to stderr with the level ("debug", "info", "warn", "error")
+// and the format ("json" or "console").
+func New(level, format string) (*zap.Logger, error) {
+	var zapLevel zapcore.Level
+	if err := zapLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	var cfg zap.Config
+	switch format {
+	case "json":
+		cfg = zap.NewProductionConfig()
+	case "console":
+		cfg = zap.NewDevelopmentConfig()
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+	cfg.Level = zap.NewAtomicLevelAt(zapLevel)
+	cfg.EncoderConfig.TimeKey = "time"
+	cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	return cfg.Build()
+}
+
+// WithContext stores the request scoped logger.
+func WithContext(ctx context.Context, l *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// FromContext returns the request scoped logger or the global one.
+func FromContext(ctx context.Context) *zap.Logger {
+	if l, ok := ctx.Value(loggerKey).(*zap.Logger); ok {
+		return l
+	}
+	return zap.L()
+}
+
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// WithUserSlot reserves a place for the user of the request, it is filled later by SetUser
+// in inner handlers and read by the access log after the response.
+func WithUserSlot(ctx context.Context) (context.Context, *string) {
+	user := new(string)
+	return context.WithValue(ctx, userKey, user), user
+}
+
+func SetUser(ctx context.Context, login string) {
+	if user, ok := ctx.Value(userKey).(*string); ok {
+		*user = login
+	}
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "gophermart"
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	AccrualPolls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "accrual_polls_total",
+		Help:      "Requests to the accrual system by response status code, \"error\" for failed requests.",
+	}, []string{"status"})
+
+	PointsAccrued = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "points_accrued_total",
+		Help:      "Loyalty points credited to users.",
+	})
+
+	PointsWithdrawn = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "points_withdrawn_total",
+		Help:      "Loyalty points withdrawn by users.",
+	})
+
+	Registrations = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registrations_total",
+		Help:      "Registered users.",
+	})
+)
+
+// RegisterAccrualQueue exposes the number of orders waiting for the accrual system.
+func RegisterAccrualQueue(depth func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "accrual_queue_depth",
+		Help:      "Orders waiting to be checked in the accrual system.",
+	}, func() float64 {
+		return float64(depth())
+	})
+}
+
+// RegisterDB exposes the connection pool statistics of the database.
+func RegisterDB(db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, namespace))
+}
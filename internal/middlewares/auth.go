@@ -2,34 +2,67 @@ package middleware
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"net/http"
+
+	"github.com/tim3-p/go-ya-diplom/internal/logger"
+	"github.com/tim3-p/go-ya-diplom/internal/models"
+	"github.com/tim3-p/go-ya-diplom/internal/problem"
 )
 
 type ContextKey string
 
-const ContextLoginKey ContextKey = "loginKey"
+const (
+	ContextLoginKey ContextKey = "loginKey"
+	ContextUserKey  ContextKey = "userKey"
+)
 
 type CookieAuthenticatorChecker interface {
 	GetLogin(r *http.Request) (string, error)
 }
 
+type UserGetter interface {
+	GetByLogin(ctx context.Context, login string) (models.User, error)
+}
+
+// Authenticator checks the session cookie and that the account is not locked, before
+// any other middleware such as Idempotency answers the request. The loaded user is put
+// in the request context for the handlers.
 type Authenticator struct {
 	cookieAuthenticator CookieAuthenticatorChecker
+	users               UserGetter
 }
 
-func NewAuthenticator(cookieAuthenticator CookieAuthenticatorChecker) *Authenticator {
-	return &Authenticator{cookieAuthenticator: cookieAuthenticator}
+func NewAuthenticator(cookieAuthenticator CookieAuthenticatorChecker, users UserGetter) *Authenticator {
+	return &Authenticator{cookieAuthenticator: cookieAuthenticator, users: users}
 }
 
 func (a Authenticator) Handle(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		login, err := a.cookieAuthenticator.GetLogin(r)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
+			problem.Write(w, r, problem.New(problem.Unauthorized, "unauthorized", err))
+			return
+		}
+
+		user, err := a.users.GetByLogin(r.Context(), login)
+		if errors.Is(err, sql.ErrNoRows) {
+			problem.Write(w, r, problem.New(problem.Unauthorized, "unauthorized", err))
+			return
+		}
+		if err != nil {
+			problem.Write(w, r, err)
+			return
+		}
+		if user.Locked {
+			problem.Write(w, r, problem.New(problem.Forbidden, "account is locked", nil))
 			return
 		}
 
+		logger.SetUser(r.Context(), login)
 		ctx := context.WithValue(r.Context(), ContextLoginKey, login)
+		ctx = context.WithValue(ctx, ContextUserKey, user)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
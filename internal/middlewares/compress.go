@@ -0,0 +1,342 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/tim3-p/go-ya-diplom/internal/problem"
+)
+
+// DefaultCompressibleTypes are the media types compressed by the Compressor unless others are given.
+var DefaultCompressibleTypes = []string{
+	"application/json",
+	"application/problem+json",
+	"application/javascript",
+	"application/xml",
+	"text/",
+}
+
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+type encoding struct {
+	name string
+	pool *sync.Pool
+}
+
+// encodings are listed in the order of preference when the client accepts several with the same q-value.
+var encodings = []encoding{
+	{name: "br", pool: &sync.Pool{New: func() interface{} {
+		return brotli.NewWriterLevel(nil, 4)
+	}}},
+	{name: "zstd", pool: &sync.Pool{New: func() interface{} {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+		return w
+	}}},
+	{name: "gzip", pool: &sync.Pool{New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+		return w
+	}}},
+	{name: "deflate", pool: &sync.Pool{New: func() interface{} {
+		w, _ := zlib.NewWriterLevel(nil, zlib.BestSpeed)
+		return w
+	}}},
+}
+
+// negotiateEncoding picks the encoding with the highest q-value from Accept-Encoding.
+// It returns nil when the response should not be encoded.
+func negotiateEncoding(header string) *encoding {
+	if header == "" {
+		return nil
+	}
+
+	values := map[string]float64{}
+	for _, item := range strings.Split(header, ",") {
+		name, q := parseQValue(item)
+		if name != "" {
+			values[name] = q
+		}
+	}
+
+	var best *encoding
+	bestQ := 0.0
+	for i := range encodings {
+		q, ok := values[encodings[i].name]
+		if !ok {
+			q, ok = values["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = &encodings[i], q
+		}
+	}
+
+	return best
+}
+
+func parseQValue(item string) (string, float64) {
+	parts := strings.Split(item, ";")
+	name := strings.ToLower(strings.TrimSpace(parts[0]))
+	q := 1.0
+	for _, param := range parts[1:] {
+		param = strings.TrimSpace(param)
+		if !strings.HasPrefix(param, "q=") {
+			continue
+		}
+		value, err := strconv.ParseFloat(param[2:], 64)
+		if err != nil || value < 0 || value > 1 {
+			return "", 0
+		}
+		q = value
+	}
+
+	return name, q
+}
+
+// Compressor encodes responses with the best encoding accepted by the client. Responses smaller
+// than minSize, of other content types or already encoded are sent as is.
+type Compressor struct {
+	minSize      int
+	contentTypes []string
+}
+
+func NewCompressor(minSize int, contentTypes []string) *Compressor {
+	return &Compressor{minSize: minSize, contentTypes: contentTypes}
+}
+
+func (c *Compressor) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == nil || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, compressor: c, encoding: encoding}
+		defer cw.Close()
+
+		next.ServeHTTP(cw, r)
+	}
+}
+
+func (c *Compressor) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range c.contentTypes {
+		if strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediaType, allowed) || mediaType == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compressWriter buffers the beginning of the response until it is known whether it is worth encoding.
+type compressWriter struct {
+	http.ResponseWriter
+	compressor *Compressor
+	encoding   *encoding
+
+	statusCode int
+	buffer     bytes.Buffer
+	decided    bool
+	encoder    encoder
+}
+
+func (w *compressWriter) WriteHeader(statusCode int) {
+	if w.statusCode != 0 {
+		return
+	}
+	w.statusCode = statusCode
+
+	if statusCode < http.StatusOK || statusCode == http.StatusNoContent || statusCode == http.StatusNotModified {
+		w.decide(false)
+		return
+	}
+
+	if length := w.Header().Get("Content-Length"); length != "" {
+		if size, err := strconv.Atoi(length); err == nil && size < w.compressor.minSize {
+			w.decide(false)
+		}
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if !w.decided {
+		w.buffer.Write(b)
+		if w.buffer.Len() >= w.compressor.minSize {
+			if err := w.decide(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends the buffered part right away, streamed responses are encoded regardless of their size.
+func (w *compressWriter) Flush() {
+	if w.statusCode == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *compressWriter) Close() error {
+	if w.statusCode == 0 {
+		// Nothing was written, the server sends an empty 200 response itself.
+		return nil
+	}
+	if !w.decided {
+		if err := w.decide(w.buffer.Len() >= w.compressor.minSize); err != nil {
+			return err
+		}
+	}
+	if w.encoder == nil {
+		return nil
+	}
+
+	err := w.encoder.Close()
+	w.encoder.Reset(nil)
+	w.encoding.pool.Put(w.encoder)
+	w.encoder = nil
+	return err
+}
+
+// decide writes the header and the buffered part, encoded when allowed and worth it.
+func (w *compressWriter) decide(allowed bool) error {
+	w.decided = true
+	header := w.Header()
+
+	if header.Get("Content-Type") == "" && w.buffer.Len() > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buffer.Bytes()))
+	}
+
+	if allowed && header.Get("Content-Encoding") == "" && w.compressor.compressible(header.Get("Content-Type")) {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", w.encoding.name)
+		w.encoder = w.encoding.pool.Get().(encoder)
+		w.encoder.Reset(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(w.statusCode)
+	if w.buffer.Len() == 0 {
+		return nil
+	}
+
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(w.buffer.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buffer.Bytes())
+	}
+	w.buffer.Reset()
+	return err
+}
+
+// maxDecompressedBodySize protects the handlers from compression bombs.
+const maxDecompressedBodySize = 10 << 20
+
+var errDecompressedBodyTooLarge = errors.New("decompressed body is too large")
+
+// Decompressor decodes request bodies sent with Content-Encoding. The body is decoded before the
+// handler is called, so that a corrupt body is answered with 400.
+type Decompressor struct{}
+
+func (d Decompressor) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Content-Encoding")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Encodings are listed in the order they were applied.
+		names := strings.Split(header, ",")
+		var body io.Reader = r.Body
+		for i := len(names) - 1; i >= 0; i-- {
+			reader, err := newDecoder(strings.ToLower(strings.TrimSpace(names[i])), body)
+			if err != nil {
+				var perr *problem.Error
+				if !errors.As(err, &perr) {
+					err = problem.New(problem.BadRequest, "corrupt request body", err)
+				}
+				problem.Write(w, r, err)
+				return
+			}
+			defer reader.Close()
+			body = reader
+		}
+
+		decoded, err := io.ReadAll(io.LimitReader(body, maxDecompressedBodySize+1))
+		if err != nil {
+			problem.Write(w, r, problem.New(problem.BadRequest, "corrupt request body", err))
+			return
+		}
+		if len(decoded) > maxDecompressedBodySize {
+			problem.Write(w, r, problem.New(problem.TooLarge, "request body is too large", errDecompressedBodyTooLarge))
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(decoded))
+		r.ContentLength = int64(len(decoded))
+		r.Header.Del("Content-Encoding")
+		r.Header.Set("Content-Length", strconv.Itoa(len(decoded)))
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+func newDecoder(name string, body io.Reader) (io.ReadCloser, error) {
+	switch name {
+	case "identity":
+		return io.NopCloser(body), nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		return zlib.NewReader(body)
+	case "br":
+		return io.NopCloser(brotli.NewReader(body)), nil
+	case "zstd":
+		decoder, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxDecompressedBodySize))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+
+	return nil, problem.New(problem.UnsupportedMediaType, "unsupported content encoding "+name, nil)
+}
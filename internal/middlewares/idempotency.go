@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/tim3-p/go-ya-diplom/internal/logger"
+	"github.com/tim3-p/go-ya-diplom/internal/models"
+	"github.com/tim3-p/go-ya-diplom/internal/problem"
+	"go.uber.org/zap"
+)
+
+const (
+	IdempotencyKeyHeader    = "Idempotency-Key"
+	idempotencyKeyMaxLength = 255
+	// idempotencyMaxBodySize bounds the body read for the fingerprint, handlers apply their own limits.
+	idempotencyMaxBodySize = 1 << 20
+	// idempotencyLease is how long a reservation without a response blocks the key. A reservation
+	// is left behind when the process dies during the request, afterwards the key can be reused.
+	idempotencyLease = time.Minute
+	// idempotencyRetention is how long keys and their responses are kept.
+	idempotencyRetention = 24 * time.Hour
+)
+
+type IdempotencyStore interface {
+	Reserve(ctx context.Context, login, key, fingerprint string, lease time.Duration) (models.IdempotentResponse, bool, error)
+	Save(ctx context.Context, login, key string, response models.IdempotentResponse) error
+	Release(ctx context.Context, login, key string) error
+	Cleanup(ctx context.Context, before time.Time) error
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *responseRecorder) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Idempotency replays the stored response for repeated requests with the same Idempotency-Key.
+// It must be placed after the Authenticator, keys are scoped per login.
+type Idempotency struct {
+	store IdempotencyStore
+}
+
+func NewIdempotency(store IdempotencyStore) *Idempotency {
+	return &Idempotency{store: store}
+}
+
+func (m Idempotency) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > idempotencyKeyMaxLength {
+			problem.Write(w, r, problem.New(problem.BadRequest, "idempotency key is too long", nil))
+			return
+		}
+
+		login, ok := r.Context().Value(ContextLoginKey).(string)
+		if !ok {
+			problem.Write(w, r, problem.New(problem.Unauthorized, "unauthorized", nil))
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, idempotencyMaxBodySize+1))
+		if err != nil {
+			problem.Write(w, r, problem.New(problem.BadRequest, "could not read request body", err))
+			return
+		}
+		if len(body) > idempotencyMaxBodySize {
+			problem.Write(w, r, problem.New(problem.TooLarge, "request body is too large", nil))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := requestFingerprint(r, body)
+		stored, found, err := m.store.Reserve(r.Context(), login, key, fingerprint, idempotencyLease)
+		if err != nil {
+			problem.Write(w, r, err)
+			return
+		}
+
+		if found {
+			switch {
+			case stored.Fingerprint != fingerprint:
+				problem.Write(w, r, problem.New(problem.Validation, "idempotency key has already been used with another request", nil))
+			case stored.StatusCode == 0:
+				problem.Write(w, r, problem.New(problem.Conflict, "request with this idempotency key is in progress", nil))
+			default:
+				if stored.ContentType != "" {
+					w.Header().Set("Content-Type", stored.ContentType)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.StatusCode)
+				w.Write(stored.Body)
+			}
+			return
+		}
+
+		defer func() {
+			if p := recover(); p != nil {
+				m.release(r, login, key)
+				panic(p)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.statusCode == 0 {
+			recorder.statusCode = http.StatusOK
+		}
+
+		// Server errors are not stored so that the client is able to retry the request.
+		if recorder.statusCode >= http.StatusInternalServerError {
+			m.release(r, login, key)
+			return
+		}
+
+		err = m.store.Save(context.Background(), login, key, models.IdempotentResponse{
+			Fingerprint: fingerprint,
+			StatusCode:  recorder.statusCode,
+			ContentType: recorder.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+		})
+		if err != nil {
+			// The response has been sent, the reservation expires after the lease.
+			logger.FromContext(r.Context()).Error("could not save idempotent response", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
+
+func (m Idempotency) release(r *http.Request, login, key string) {
+	if err := m.store.Release(context.Background(), login, key); err != nil {
+		logger.FromContext(r.Context()).Error("could not release idempotency key", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// RunCleanup removes expired keys every interval until ctx is done.
+func (m Idempotency) RunCleanup(ctx context.Context, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := m.store.Cleanup(ctx, time.Now().Add(-idempotencyRetention)); err != nil && ctx.Err() == nil {
+			logger.Error("could not clean up idempotency keys", zap.Error(err))
+		}
+	}
+}
+
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte(r.URL.Path))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
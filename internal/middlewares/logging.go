@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/tim3-p/go-ya-diplom/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	RequestIDHeader    = "X-Request-ID"
+	requestIDMaxLength = 128
+)
+
+// RequestID takes the request ID from the X-Request-ID header or generates a new one
+// and puts it together with a request scoped logger into the context.
+type RequestID struct {
+	logger *zap.Logger
+}
+
+func NewRequestID(logger *zap.Logger) *RequestID {
+	return &RequestID{logger: logger}
+}
+
+func (m RequestID) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+
+		ctx := logger.WithRequestID(r.Context(), requestID)
+		ctx = logger.WithContext(ctx, m.logger.With(zap.String("request_id", requestID)))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > requestIDMaxLength {
+		return false
+	}
+
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] < 0x21 || requestID[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	statusCode int
+	bytes      int
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// AccessLog writes one log entry per request, it must be placed after RequestID.
+type AccessLog struct{}
+
+func (m AccessLog) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx, user := logger.WithUserSlot(r.Context())
+		sw := &statusWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		if sw.statusCode == 0 {
+			sw.statusCode = http.StatusOK
+		}
+
+		logger.FromContext(ctx).Info("request",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", sw.statusCode),
+			zap.Int("bytes", sw.bytes),
+			zap.Duration("latency", time.Since(start)),
+			zap.String("user", *user),
+			zap.String("remote_addr", r.RemoteAddr),
+		)
+	}
+}
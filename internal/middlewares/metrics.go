@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/tim3-p/go-ya-diplom/internal/metrics"
+)
+
+// Metrics counts requests and observes their latency by route pattern. It must wrap the router,
+// the route context is created here so that the pattern is known after the request is served.
+type Metrics struct{}
+
+func (m Metrics) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rctx := chi.NewRouteContext()
+		sw := &statusWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx)))
+
+		if sw.statusCode == 0 {
+			sw.statusCode = http.StatusOK
+		}
+
+		route := rctx.RoutePattern()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		metrics.HTTPRequests.WithLabelValues(route, r.Method, strconv.Itoa(sw.statusCode)).Inc()
+		metrics.HTTPDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	}
+}
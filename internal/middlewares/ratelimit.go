@@ -0,0 +1,209 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tim3-p/go-ya-diplom/internal/problem"
+)
+
+// RateLimitPolicy allows Limit requests per Window. A zero Limit disables the limit.
+type RateLimitPolicy struct {
+	Limit  int
+	Window time.Duration
+}
+
+// ParseRateLimitPolicy parses policies like "60/1m". An empty string or "0" disables the limit.
+func ParseRateLimitPolicy(value string) (RateLimitPolicy, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "0" {
+		return RateLimitPolicy{}, nil
+	}
+
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return RateLimitPolicy{}, fmt.Errorf("rate limit %q must look like 60/1m", value)
+	}
+
+	limit, err := strconv.Atoi(parts[0])
+	if err != nil || limit < 0 {
+		return RateLimitPolicy{}, fmt.Errorf("invalid rate limit %q", parts[0])
+	}
+
+	window, err := time.ParseDuration(parts[1])
+	if err != nil || window <= 0 {
+		return RateLimitPolicy{}, fmt.Errorf("invalid rate limit window %q", parts[1])
+	}
+
+	return RateLimitPolicy{Limit: limit, Window: window}, nil
+}
+
+// ParseTrustedProxies parses a comma separated list of IP addresses and CIDR networks.
+func ParseTrustedProxies(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", item)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+type rateLimitWindow struct {
+	start time.Time
+	count int
+}
+
+// RateLimiter counts requests of every client in fixed windows. Clients are identified by the login
+// when the request is authenticated and by the IP address otherwise, so for authenticated routes
+// it must be placed after the Authenticator. Behind a proxy that is not trusted all anonymous
+// requests come from the proxy address and share one limit.
+type RateLimiter struct {
+	name           string
+	policy         RateLimitPolicy
+	trustedProxies []*net.IPNet
+
+	mu        sync.Mutex
+	windows   map[string]*rateLimitWindow
+	lastSweep time.Time
+}
+
+func NewRateLimiter(name string, policy RateLimitPolicy, trustedProxies []*net.IPNet) *RateLimiter {
+	return &RateLimiter{
+		name:           name,
+		policy:         policy,
+		trustedProxies: trustedProxies,
+		windows:        map[string]*rateLimitWindow{},
+	}
+}
+
+func (m *RateLimiter) Handle(next http.HandlerFunc) http.HandlerFunc {
+	if m.policy.Limit <= 0 {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := "ip:" + m.clientIP(r)
+		if login, ok := r.Context().Value(ContextLoginKey).(string); ok {
+			key = "login:" + login
+		}
+
+		remaining, reset := m.take(key, time.Now())
+		resetSeconds := strconv.Itoa(int(math.Ceil(reset.Seconds())))
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(m.policy.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(max(remaining, 0)))
+		w.Header().Set("RateLimit-Reset", resetSeconds)
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", m.policy.Limit, int(m.policy.Window.Seconds())))
+
+		if remaining < 0 {
+			w.Header().Set("Retry-After", resetSeconds)
+			problem.Write(w, r, problem.New(problem.TooManyRequests, "rate limit exceeded for "+m.name, nil))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// take counts the request and returns the number of requests left in the window,
+// negative when the limit is exceeded, and the time until the window is reset.
+func (m *RateLimiter) take(key string, now time.Time) (int, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Windows of clients that have gone are dropped once per window.
+	if now.Sub(m.lastSweep) >= m.policy.Window {
+		for k, window := range m.windows {
+			if now.Sub(window.start) >= m.policy.Window {
+				delete(m.windows, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	window, ok := m.windows[key]
+	if !ok || now.Sub(window.start) >= m.policy.Window {
+		window = &rateLimitWindow{start: now}
+		m.windows[key] = window
+	}
+
+	window.count++
+	return m.policy.Limit - window.count, window.start.Add(m.policy.Window).Sub(now)
+}
+
+// clientIP returns the address of the peer. When the peer is a trusted proxy X-Forwarded-For is read
+// from the right and the first address that is not a trusted proxy is used.
+func (m *RateLimiter) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !m.trusted(net.ParseIP(host)) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwarded[i])
+		ip := net.ParseIP(address)
+		if ip == nil {
+			break
+		}
+		host = address
+		if !m.trusted(ip) {
+			break
+		}
+	}
+
+	return host
+}
+
+func (m *RateLimiter) trusted(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range m.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
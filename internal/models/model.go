@@ -1,7 +1,12 @@
 package models
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -10,6 +15,9 @@ var (
 	Processing = "PROCESSING"
 	Invalid    = "INVALID"
 	Processed  = "PROCESSED"
+
+	// Registered is reported only by the accrual system, such orders are stored as NEW.
+	Registered = "REGISTERED"
 )
 
 type Accrual struct {
@@ -18,17 +26,13 @@ type Accrual struct {
 	Accrual float64 `json:"accrual"`
 }
 
-type Credentials struct {
-	Login    string
-	Password string
-}
-
 type User struct {
 	ID           uint64  `json:"-"`
 	Login        string  `json:"-"`
 	PasswordHash string  `json:"-"`
 	Balance      float64 `json:"current"`
 	Withdrawn    float64 `json:"withdrawn"`
+	Locked       bool    `json:"-"`
 }
 
 type Order struct {
@@ -75,3 +79,379 @@ func (o Order) MarshalJSON() ([]byte, error) {
 
 	return json.Marshal(aliasValue)
 }
+
+type IdempotentResponse struct {
+	Fingerprint string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+var (
+	Credit     = "credit"
+	Debit      = "debit"
+	Adjustment = "adjustment"
+)
+
+// StatementEntry is a change of the balance. Adjustment amounts are signed, the others are positive.
+type StatementEntry struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Order     string    `json:"order"`
+	Reason    string    `json:"reason,omitempty"`
+	Amount    float64   `json:"amount"`
+	Balance   float64   `json:"balance"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Statement struct {
+	Entries    []StatementEntry `json:"entries"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+func (e StatementEntry) MarshalJSON() ([]byte, error) {
+	type StatementEntryAlias StatementEntry
+
+	aliasValue := struct {
+		StatementEntryAlias
+		CreatedAt string `json:"created_at"`
+	}{
+		StatementEntryAlias: StatementEntryAlias(e),
+		CreatedAt:           e.CreatedAt.Format(time.RFC3339),
+	}
+
+	return json.Marshal(aliasValue)
+}
+
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uint64
+}
+
+func (c Cursor) Encode() string {
+	raw := fmt.Sprintf("%d:%d", c.CreatedAt.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(value string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return Cursor{}, err
+	}
+
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return Cursor{}, errors.New("invalid cursor")
+	}
+
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Cursor{}, err
+	}
+
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return Cursor{}, err
+	}
+
+	return Cursor{CreatedAt: time.Unix(0, nanos).UTC(), ID: id}, nil
+}
+
+// StatementCursor points at a statement entry. Entries come from several tables, so the ID
+// is the prefixed ID of the entry rather than a row ID.
+type StatementCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+func (c StatementCursor) Encode() string {
+	raw := fmt.Sprintf("%d:%s", c.CreatedAt.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeStatementCursor(value string) (StatementCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return StatementCursor{}, err
+	}
+
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return StatementCursor{}, errors.New("invalid cursor")
+	}
+
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return StatementCursor{}, err
+	}
+
+	return StatementCursor{CreatedAt: time.Unix(0, nanos).UTC(), ID: parts[1]}, nil
+}
+
+type StatementFilter struct {
+	From  time.Time
+	To    time.Time
+	After *StatementCursor
+	Limit int
+}
+
+type ListFilter struct {
+	Statuses []string
+	From     time.Time
+	To       time.Time
+	After    *Cursor
+	Limit    int
+}
+
+type OrderStatusChange struct {
+	Status    string    `json:"status"`
+	Accrual   float64   `json:"accrual,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OrderDetails struct {
+	Order         Order
+	PollAttempts  int
+	LastCheckedAt *time.Time
+	History       []OrderStatusChange
+}
+
+func (c OrderStatusChange) MarshalJSON() ([]byte, error) {
+	type OrderStatusChangeAlias OrderStatusChange
+
+	aliasValue := struct {
+		OrderStatusChangeAlias
+		CreatedAt string `json:"created_at"`
+	}{
+		OrderStatusChangeAlias: OrderStatusChangeAlias(c),
+		CreatedAt:              c.CreatedAt.Format(time.RFC3339),
+	}
+
+	return json.Marshal(aliasValue)
+}
+
+func (d OrderDetails) MarshalJSON() ([]byte, error) {
+	var lastCheckedAt string
+	if d.LastCheckedAt != nil {
+		lastCheckedAt = d.LastCheckedAt.Format(time.RFC3339)
+	}
+
+	value := struct {
+		Number        string              `json:"number"`
+		Status        string              `json:"status"`
+		Accrual       float64             `json:"accrual"`
+		CreatedAt     string              `json:"created_at"`
+		PollAttempts  int                 `json:"poll_attempts"`
+		LastCheckedAt string              `json:"last_checked_at,omitempty"`
+		History       []OrderStatusChange `json:"history"`
+	}{
+		Number:        d.Order.Number,
+		Status:        d.Order.Status,
+		Accrual:       d.Order.Accrual,
+		CreatedAt:     d.Order.CreatedAt.Format(time.RFC3339),
+		PollAttempts:  d.PollAttempts,
+		LastCheckedAt: lastCheckedAt,
+		History:       d.History,
+	}
+
+	return json.Marshal(value)
+}
+
+var (
+	BatchAccepted        = "accepted"
+	BatchAlreadyUploaded = "already_uploaded"
+	BatchConflict        = "uploaded_by_another_user"
+	BatchInvalid         = "invalid"
+)
+
+type BatchOrderResult struct {
+	Number string `json:"number"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+var (
+	EventOrderStatusChanged = "order.status_changed"
+	EventOrderCredited      = "order.credited"
+	EventWithdrawalCreated  = "withdrawal.created"
+)
+
+type Event struct {
+	ID        uint64    `json:"id"`
+	Type      string    `json:"type"`
+	UserID    uint64    `json:"-"`
+	Order     string    `json:"order"`
+	Status    string    `json:"status,omitempty"`
+	Amount    float64   `json:"amount,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	type EventAlias Event
+
+	aliasValue := struct {
+		EventAlias
+		CreatedAt string `json:"created_at"`
+	}{
+		EventAlias: EventAlias(e),
+		CreatedAt:  e.CreatedAt.Format(time.RFC3339),
+	}
+
+	return json.Marshal(aliasValue)
+}
+
+var (
+	WebhookOrderProcessed    = "order.processed"
+	WebhookOrderInvalid      = "order.invalid"
+	WebhookWithdrawalCreated = "withdrawal.created"
+)
+
+var (
+	DeliveryPending   = "PENDING"
+	DeliveryDelivered = "DELIVERED"
+	DeliveryFailed    = "FAILED"
+)
+
+type Webhook struct {
+	ID        uint64    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    uint64    `json:"-"`
+}
+
+type WebhookDelivery struct {
+	ID             uint64     `json:"id"`
+	WebhookID      uint64     `json:"webhook_id"`
+	Event          string     `json:"event"`
+	Payload        []byte     `json:"-"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	LastStatusCode int        `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	NextAttemptAt  time.Time  `json:"-"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	URL            string     `json:"-"`
+	Secret         string     `json:"-"`
+}
+
+type DeliveryAttempt struct {
+	StatusCode int
+	Error      string
+}
+
+func (w Webhook) MarshalJSON() ([]byte, error) {
+	type WebhookAlias Webhook
+
+	aliasValue := struct {
+		WebhookAlias
+		CreatedAt string `json:"created_at"`
+	}{
+		WebhookAlias: WebhookAlias(w),
+		CreatedAt:    w.CreatedAt.Format(time.RFC3339),
+	}
+
+	return json.Marshal(aliasValue)
+}
+
+func (d WebhookDelivery) MarshalJSON() ([]byte, error) {
+	type WebhookDeliveryAlias WebhookDelivery
+
+	var deliveredAt string
+	if d.DeliveredAt != nil {
+		deliveredAt = d.DeliveredAt.Format(time.RFC3339)
+	}
+
+	aliasValue := struct {
+		WebhookDeliveryAlias
+		Payload     json.RawMessage `json:"payload"`
+		CreatedAt   string          `json:"created_at"`
+		DeliveredAt string          `json:"delivered_at,omitempty"`
+	}{
+		WebhookDeliveryAlias: WebhookDeliveryAlias(d),
+		Payload:              json.RawMessage(d.Payload),
+		CreatedAt:            d.CreatedAt.Format(time.RFC3339),
+		DeliveredAt:          deliveredAt,
+	}
+
+	return json.Marshal(aliasValue)
+}
+
+var (
+	AccrualSystemOK      = "ok"
+	AccrualSystemFailing = "failing"
+)
+
+type AccrualStatus struct {
+	Running             bool       `json:"running"`
+	QueueLength         int        `json:"queue_length"`
+	SystemState         string     `json:"system_state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastSuccessAt       *time.Time `json:"last_success_at,omitempty"`
+	LastFailureAt       *time.Time `json:"last_failure_at,omitempty"`
+}
+
+type Check struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+type Readiness struct {
+	Ready  bool    `json:"ready"`
+	Checks []Check `json:"checks"`
+}
+
+type DBStatus struct {
+	OpenConnections int   `json:"open_connections"`
+	InUse           int   `json:"in_use"`
+	Idle            int   `json:"idle"`
+	WaitCount       int64 `json:"wait_count"`
+}
+
+type SystemStatus struct {
+	Readiness
+	StartedAt        time.Time      `json:"started_at"`
+	Uptime           string         `json:"uptime"`
+	MigrationVersion uint           `json:"migration_version"`
+	Accrual          *AccrualStatus `json:"accrual,omitempty"`
+	DB               DBStatus       `json:"db"`
+}
+
+// UserSummary describes an account for administrators.
+type UserSummary struct {
+	ID          uint64     `json:"id"`
+	Login       string     `json:"login"`
+	Balance     float64    `json:"balance"`
+	Withdrawn   float64    `json:"withdrawn"`
+	Orders      int        `json:"orders"`
+	Withdrawals int        `json:"withdrawals"`
+	LockedAt    *time.Time `json:"locked_at,omitempty"`
+}
+
+// OrderSummary is an order with its owner and polling state for administrators.
+type OrderSummary struct {
+	Number        string     `json:"number"`
+	Login         string     `json:"login"`
+	Status        string     `json:"status"`
+	Accrual       float64    `json:"accrual"`
+	PollAttempts  int        `json:"poll_attempts"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastCheckedAt *time.Time `json:"last_checked_at,omitempty"`
+}
+
+type BalanceAdjustment struct {
+	ID        uint64    `json:"-"`
+	Login     string    `json:"login"`
+	Amount    float64   `json:"amount"`
+	Reason    string    `json:"reason"`
+	Balance   float64   `json:"balance"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type MigrationVersion struct {
+	Version uint `json:"version"`
+	Dirty   bool `json:"dirty"`
+}
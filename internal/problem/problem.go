@@ -0,0 +1,157 @@
+package problem
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/tim3-p/go-ya-diplom/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	ContentType         = "application/problem+json"
+	CorrelationIDHeader = "X-Correlation-ID"
+	RequestIDHeader     = "X-Request-ID"
+)
+
+type Kind string
+
+const (
+	BadRequest           Kind = "bad_request"
+	Validation           Kind = "validation_failed"
+	Unauthorized         Kind = "unauthorized"
+	InsufficientFunds    Kind = "insufficient_funds"
+	Forbidden            Kind = "forbidden"
+	NotFound             Kind = "not_found"
+	Conflict             Kind = "conflict"
+	TooLarge             Kind = "payload_too_large"
+	TooManyRequests      Kind = "too_many_requests"
+	UnsupportedMediaType Kind = "unsupported_media_type"
+	Internal             Kind = "internal_error"
+)
+
+type entry struct {
+	status int
+	title  string
+}
+
+var catalogue = map[Kind]entry{
+	BadRequest:           {http.StatusBadRequest, "Malformed request"},
+	Validation:           {http.StatusUnprocessableEntity, "Validation failed"},
+	Unauthorized:         {http.StatusUnauthorized, "Authentication required"},
+	InsufficientFunds:    {http.StatusPaymentRequired, "Insufficient funds"},
+	Forbidden:            {http.StatusForbidden, "Access denied"},
+	NotFound:             {http.StatusNotFound, "Resource not found"},
+	Conflict:             {http.StatusConflict, "Conflict"},
+	TooLarge:             {http.StatusRequestEntityTooLarge, "Payload too large"},
+	TooManyRequests:      {http.StatusTooManyRequests, "Too many requests"},
+	UnsupportedMediaType: {http.StatusUnsupportedMediaType, "Unsupported media type"},
+	Internal:             {http.StatusInternalServerError, "Internal server error"},
+}
+
+// Error is an error with a kind from the catalogue. Detail is shown to clients,
+// Cause is only logged.
+type Error struct {
+	Kind   Kind
+	Detail string
+	Cause  error
+	Fields []FieldError
+}
+
+// FieldError describes an invalid field of the request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func New(kind Kind, detail string, cause error) *Error {
+	return &Error{Kind: kind, Detail: detail, Cause: cause}
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return e.Detail + ": " + e.Cause.Error()
+	}
+	return e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+func (e *Error) Status() int {
+	return catalogue[e.Kind].status
+}
+
+type document struct {
+	Type          string       `json:"type"`
+	Title         string       `json:"title"`
+	Status        int          `json:"status"`
+	Detail        string       `json:"detail,omitempty"`
+	Instance      string       `json:"instance,omitempty"`
+	Code          Kind         `json:"code"`
+	CorrelationID string       `json:"correlation_id"`
+	Errors        []FieldError `json:"errors,omitempty"`
+}
+
+// Write renders err as a problem document. Errors without a kind are reported as internal
+// errors without exposing their text.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = New(Internal, "", err)
+	}
+
+	if _, ok := catalogue[e.Kind]; !ok {
+		e = New(Internal, "", err)
+	}
+
+	if e.Kind == Internal {
+		e = New(Internal, "", e.Cause)
+	}
+
+	correlationID := CorrelationID(r)
+	if e.Kind == Internal {
+		logger.FromContext(r.Context()).Error("internal error",
+			zap.String("correlation_id", correlationID),
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Error(e.Cause),
+		)
+	}
+
+	entry := catalogue[e.Kind]
+	res, _ := json.Marshal(document{
+		Type:          "about:blank",
+		Title:         entry.title,
+		Status:        entry.status,
+		Detail:        e.Detail,
+		Instance:      r.URL.Path,
+		Code:          e.Kind,
+		CorrelationID: correlationID,
+		Errors:        e.Fields,
+	})
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set(CorrelationIDHeader, correlationID)
+	w.WriteHeader(entry.status)
+	w.Write(res)
+}
+
+// CorrelationID returns the ID of the request or a new random one.
+func CorrelationID(r *http.Request) string {
+	if id := logger.RequestIDFromContext(r.Context()); id != "" {
+		return id
+	}
+
+	if id := r.Header.Get(RequestIDHeader); id != "" {
+		return id
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
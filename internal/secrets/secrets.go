@@ -0,0 +1,76 @@
+// Package secrets encrypts values that must be stored but read back in plain text,
+// like webhook signing secrets.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// sealedPrefix marks encrypted values, values without it were stored before encryption.
+const sealedPrefix = "v1:"
+
+var errMalformed = errors.New("malformed sealed value")
+
+// Box seals values with AES-GCM under a key derived from the application key.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// NewBox derives the encryption key from key and purpose, so the application key is
+// never used directly and different purposes get different keys.
+func NewBox(key []byte, purpose string) (*Box, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Box{aead: aead}, nil
+}
+
+func (b *Box) Seal(plaintext string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := b.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a sealed value. Values stored before encryption are returned as they are.
+func (b *Box) Open(value string) (string, error) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil {
+		return "", errMalformed
+	}
+	if len(sealed) < b.aead.NonceSize() {
+		return "", errMalformed
+	}
+
+	nonce, ciphertext := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
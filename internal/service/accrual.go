@@ -3,83 +3,422 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/tim3-p/go-ya-diplom/internal/logger"
+	"github.com/tim3-p/go-ya-diplom/internal/metrics"
 	"github.com/tim3-p/go-ya-diplom/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
 type Order interface {
 	GetByNumber(ctx context.Context, number string) (models.Order, error)
-	UpdateAccrual(ctx context.Context, accrual models.Accrual) error
+	UpdateAccrual(ctx context.Context, accrual models.Accrual) (credited bool, err error)
+	RecordPoll(ctx context.Context, number string) error
+	GetPending(ctx context.Context) ([]string, error)
+	TakeRequeued(ctx context.Context) ([]string, error)
+	Requeue(ctx context.Context, number string) error
+}
+
+const (
+	// accrualFailingThreshold is the number of failed requests in a row after which
+	// the accrual system is reported as failing.
+	accrualFailingThreshold = 5
+
+	accrualBaseBackoff = time.Second
+	accrualMaxBackoff  = time.Minute
+	accrualTimeout     = 10 * time.Second
+)
+
+var errAccrualUnavailable = errors.New("accrual system is unavailable")
+
+// unavailableError is returned for 429 and 500 responses, retryAfter is read from Retry-After.
+type unavailableError struct {
+	status     string
+	retryAfter time.Duration
+}
+
+func (e *unavailableError) Error() string {
+	return fmt.Sprintf("%v: %s", errAccrualUnavailable, e.status)
+}
+
+func (e *unavailableError) Unwrap() error {
+	return errAccrualUnavailable
+}
+
+var tracer = otel.Tracer("github.com/tim3-p/go-ya-diplom/internal/service")
+
+// accrualJob keeps the ID and the span of the request that uploaded the order
+// for the worker logs and traces.
+type accrualJob struct {
+	order       string
+	requestID   string
+	spanContext trace.SpanContext
+	attempts    int
 }
 
 type Accrual struct {
-	orders               chan string
+	orders               chan accrualJob
 	accrualSystemAddress string
 	order                Order
+	client               *http.Client
+	logger               *zap.Logger
+	scanInterval         time.Duration
+	ctx                  context.Context
+	cancel               context.CancelFunc
+	stopping             chan struct{}
+	stopped              chan struct{}
+	stopOnce             sync.Once
+
+	mu                  sync.Mutex
+	running             bool
+	consecutiveFailures int
+	lastSuccessAt       time.Time
+	lastFailureAt       time.Time
 }
 
 func NewAccrual(
 	accrualSystemAddress string,
 	order Order,
+	scanInterval time.Duration,
+	logger *zap.Logger,
 ) *Accrual {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Accrual{
-		orders:               make(chan string, 100),
+		orders:               make(chan accrualJob, 100),
 		accrualSystemAddress: accrualSystemAddress,
 		order:                order,
+		client:               &http.Client{Timeout: accrualTimeout},
+		logger:               logger,
+		scanInterval:         scanInterval,
+		ctx:                  ctx,
+		cancel:               cancel,
+		stopping:             make(chan struct{}),
+		stopped:              make(chan struct{}),
 	}
 }
 
+// Start runs the worker and queues the orders left unchecked by the previous run.
+// Orders are persisted with the NEW or PROCESSING status until the accrual system
+// gives the final one, so jobs dropped on shutdown are restored here.
 func (s *Accrual) Start() {
+	s.setRunning(true)
 	go func() {
-		for order := range s.orders {
-			err := s.handleOrder(order)
-			if err != nil {
-				s.Accrue(order)
+		defer close(s.stopped)
+		defer s.setRunning(false)
+
+		for {
+			select {
+			case <-s.stopping:
+				if left := len(s.orders); left > 0 {
+					s.logger.Info("accrual worker stopped, orders are left for the next start", zap.Int("orders", left))
+				}
+				return
+			case job := <-s.orders:
+				pending, err := s.handleOrder(job)
+				s.recordOutcome(err)
+				switch {
+				case err != nil:
+					s.jobLogger(job).Warn("accrual request failed", zap.Error(err))
+					s.retry(job, err)
+				case pending:
+					s.retry(job, nil)
+				}
 			}
 		}
 	}()
+
+	go func() {
+		orders, err := s.order.GetPending(context.Background())
+		if err != nil {
+			s.logger.Error("could not restore pending orders", zap.Error(err))
+		}
+
+		for _, order := range orders {
+			s.enqueue(accrualJob{order: order})
+		}
+
+		s.scanRequeued()
+	}()
+}
+
+// scanRequeued queues the orders requeued by gophermartctl or uploaded through an API process
+// without the worker until the worker is stopped.
+func (s *Accrual) scanRequeued() {
+	ticker := time.NewTicker(s.scanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopping:
+			return
+		case <-ticker.C:
+		}
+
+		orders, err := s.order.TakeRequeued(context.Background())
+		if err != nil {
+			s.logger.Error("could not read requeued orders", zap.Error(err))
+			continue
+		}
+
+		for _, order := range orders {
+			s.logger.Info("order requeued", zap.String("order", order))
+			s.enqueue(accrualJob{order: order})
+		}
+	}
 }
 
-func (s *Accrual) handleOrder(order string) error {
+// Status reports whether the worker is running and how the accrual system responds.
+func (s *Accrual) Status() models.AccrualStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := models.AccrualStatus{
+		Running:             s.running,
+		QueueLength:         len(s.orders),
+		ConsecutiveFailures: s.consecutiveFailures,
+		SystemState:         models.AccrualSystemOK,
+	}
+	if s.consecutiveFailures >= accrualFailingThreshold {
+		status.SystemState = models.AccrualSystemFailing
+	}
+	if !s.lastSuccessAt.IsZero() {
+		lastSuccessAt := s.lastSuccessAt
+		status.LastSuccessAt = &lastSuccessAt
+	}
+	if !s.lastFailureAt.IsZero() {
+		lastFailureAt := s.lastFailureAt
+		status.LastFailureAt = &lastFailureAt
+	}
+
+	return status
+}
+
+func (s *Accrual) setRunning(running bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = running
+}
+
+func (s *Accrual) recordOutcome(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		s.consecutiveFailures++
+		s.lastFailureAt = time.Now()
+		return
+	}
+
+	s.consecutiveFailures = 0
+	s.lastSuccessAt = time.Now()
+}
+
+// handleOrder polls the accrual system once. pending reports that the order has no final
+// status yet and must be polled again.
+func (s *Accrual) handleOrder(job accrualJob) (pending bool, err error) {
+	order := job.order
+	ctx, span := tracer.Start(s.ctx, "accrual.poll",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithLinks(trace.Link{SpanContext: job.spanContext}),
+		trace.WithAttributes(attribute.String("order", order)),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	url := fmt.Sprintf("%s/api/orders/%s", s.accrualSystemAddress, order)
-	response, err := http.Get(url)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return false, err
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(request.Header))
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		metrics.AccrualPolls.WithLabelValues("error").Inc()
+		return false, err
+	}
+	defer response.Body.Close()
+
+	span.SetAttributes(attribute.Int("http.status_code", response.StatusCode))
+	metrics.AccrualPolls.WithLabelValues(strconv.Itoa(response.StatusCode)).Inc()
+
+	s.jobLogger(job).Debug("accrual system responded", zap.Int("status", response.StatusCode))
+
+	err = s.order.RecordPoll(ctx, order)
+	if err != nil {
+		return false, err
 	}
 
 	switch response.StatusCode {
 	case http.StatusOK:
-		defer response.Body.Close()
 		payload, err := io.ReadAll(response.Body)
 		if err != nil {
-			return err
+			return false, err
 		}
 
 		accrual := models.Accrual{}
 		if err := json.Unmarshal(payload, &accrual); err != nil {
-			return err
+			return false, err
 		}
 
-		err = s.order.UpdateAccrual(context.Background(), accrual)
+		credited, err := s.order.UpdateAccrual(ctx, accrual)
 		if err != nil {
-			return err
+			return false, err
+		}
+
+		if credited {
+			metrics.PointsAccrued.Add(accrual.Accrual)
+		}
+		s.jobLogger(job).Info("accrual updated",
+			zap.String("status", accrual.Status),
+			zap.Float64("accrual", accrual.Accrual),
+		)
+
+		return accrual.Status != models.Processed && accrual.Status != models.Invalid, nil
+	case http.StatusNoContent:
+		// The order is not registered in the accrual system yet.
+		return true, nil
+	case http.StatusTooManyRequests, http.StatusInternalServerError:
+		retryAfter, _ := strconv.Atoi(response.Header.Get("Retry-After"))
+		return false, &unavailableError{status: response.Status, retryAfter: time.Duration(retryAfter) * time.Second}
+	}
+
+	// Any other response, such as 404 or 503 from a proxy, is retried with a backoff.
+	return false, &unavailableError{status: response.Status}
+}
+
+// Stop lets the worker finish the current job and waits for it until ctx is done. Then the request
+// is canceled, the order stays pending and is restored on the next start.
+func (s *Accrual) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() {
+		close(s.stopping)
+	})
+
+	select {
+	case <-s.stopped:
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-s.stopped
+		return ctx.Err()
+	}
+}
+
+// Accrue queues the uploaded order without blocking the request, see offer.
+func (s *Accrual) Accrue(ctx context.Context, order string) {
+	s.offer(ctx, accrualJob{
+		order:       order,
+		requestID:   logger.RequestIDFromContext(ctx),
+		spanContext: trace.SpanContextFromContext(ctx),
+	})
+}
+
+// QueueLength returns the number of orders waiting to be checked.
+func (s *Accrual) QueueLength() int {
+	return len(s.orders)
+}
+
+// enqueue waits for a place in the queue. After Stop the job is dropped,
+// the order stays pending in the database.
+func (s *Accrual) enqueue(job accrualJob) {
+	select {
+	case s.orders <- job:
+	case <-s.stopping:
+	}
+}
+
+// retry puts the job back after a backoff, or after Retry-After when the accrual system sent it.
+// Pending orders are retried with a nil err.
+func (s *Accrual) retry(job accrualJob, err error) {
+	job.attempts++
+	delay := AccrualBackoff(job.attempts)
+
+	var unavailable *unavailableError
+	if errors.As(err, &unavailable) && unavailable.retryAfter > 0 {
+		delay = unavailable.retryAfter
+	}
+
+	s.retryLater(job, delay)
+}
+
+// retryLater queues the job after delay without blocking, the worker itself is the only reader of
+// the queue. Jobs waiting on Stop are dropped, the orders stay pending and are restored on the next start.
+func (s *Accrual) retryLater(job accrualJob, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		select {
+		case <-s.stopping:
+			return
+		default:
+		}
+
+		s.offer(context.Background(), job)
+	})
+}
+
+// offer queues the job if there is a place. When the queue is full the order is marked in the
+// database for the next scan.
+func (s *Accrual) offer(ctx context.Context, job accrualJob) {
+	select {
+	case s.orders <- job:
+	default:
+		s.jobLogger(job).Warn("accrual queue is full, order is left for the next scan")
+		if err := s.order.Requeue(ctx, job.order); err != nil {
+			s.jobLogger(job).Error("could not requeue order", zap.Error(err))
+		}
+	}
+}
+
+// AccrualBackoff doubles the delay with every attempt up to a minute.
+func AccrualBackoff(attempt int) time.Duration {
+	backoff := accrualBaseBackoff
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+		if backoff >= accrualMaxBackoff {
+			return accrualMaxBackoff
 		}
-	case http.StatusTooManyRequests:
-		s.Accrue(order)
-	case http.StatusInternalServerError:
-		s.Accrue(order)
 	}
 
-	return nil
+	return backoff
+}
+
+func (s *Accrual) jobLogger(job accrualJob) *zap.Logger {
+	return s.logger.With(zap.String("order", job.order), zap.String("request_id", job.requestID))
+}
+
+type OrderRequeuer interface {
+	Requeue(ctx context.Context, number string) error
 }
 
-func (s *Accrual) Stop() {
-	close(s.orders)
+// AccrualRequeuer hands uploaded orders over to a worker running in another process,
+// which picks them up on its next scan.
+type AccrualRequeuer struct {
+	order OrderRequeuer
 }
 
-func (s *Accrual) Accrue(order string) {
-	s.orders <- order
+func NewAccrualRequeuer(order OrderRequeuer) *AccrualRequeuer {
+	return &AccrualRequeuer{order: order}
+}
+
+func (s *AccrualRequeuer) Accrue(ctx context.Context, order string) {
+	if err := s.order.Requeue(ctx, order); err != nil {
+		// The order stays pending, the worker checks it after its restart.
+		logger.FromContext(ctx).Error("could not queue order for the accrual worker", zap.String("order", order), zap.Error(err))
+	}
 }
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tim3-p/go-ya-diplom/internal/models"
+	"go.uber.org/zap"
+)
+
+const (
+	subscriberBufferSize = 16
+	relayRetryInterval   = time.Second
+)
+
+type EventSource interface {
+	Listen(ctx context.Context, handle func(event models.Event)) error
+}
+
+// EventBus delivers order and balance events to subscribers of the same user inside the process.
+// Events reach it from the outbox of any process through Relay.
+// The latest events are kept so that a reconnecting client can resume after the last received one.
+type EventBus struct {
+	mu          sync.Mutex
+	lastID      uint64
+	history     []models.Event
+	historySize int
+	subscribers map[uint64]map[chan models.Event]struct{}
+}
+
+func NewEventBus(historySize int) *EventBus {
+	return &EventBus{
+		historySize: historySize,
+		subscribers: map[uint64]map[chan models.Event]struct{}{},
+	}
+}
+
+func (b *EventBus) Publish(event models.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Events relayed from the outbox keep their durable ID.
+	if event.ID == 0 {
+		event.ID = b.lastID + 1
+	}
+	if event.ID > b.lastID {
+		b.lastID = event.ID
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	b.history = append(b.history, event)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for events := range b.subscribers[event.UserID] {
+		select {
+		case events <- event:
+		default:
+			// A slow subscriber is disconnected, it resumes from the history with Last-Event-ID.
+			b.unsubscribe(event.UserID, events)
+		}
+	}
+}
+
+// Relay publishes the events of source until ctx is done. It listens again after failures,
+// events sent in between are left to the history of clients reconnecting elsewhere.
+func (b *EventBus) Relay(ctx context.Context, source EventSource, logger *zap.Logger) {
+	for {
+		err := source.Listen(ctx, b.Publish)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Error("event listener failed", zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(relayRetryInterval):
+		}
+	}
+}
+
+// Subscribe returns the kept events of the user published after lastEventID and a channel
+// with the new ones. The channel is closed when cancel is called or the subscriber falls behind.
+func (b *EventBus) Subscribe(userID uint64, lastEventID uint64) ([]models.Event, <-chan models.Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var backlog []models.Event
+	if lastEventID > 0 {
+		for _, event := range b.history {
+			if event.UserID == userID && event.ID > lastEventID {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+
+	events := make(chan models.Event, subscriberBufferSize)
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = map[chan models.Event]struct{}{}
+	}
+	b.subscribers[userID][events] = struct{}{}
+
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.unsubscribe(userID, events)
+	}
+
+	return backlog, events, cancel
+}
+
+// Close disconnects all subscribers.
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for userID, subscribers := range b.subscribers {
+		for events := range subscribers {
+			b.unsubscribe(userID, events)
+		}
+	}
+}
+
+func (b *EventBus) unsubscribe(userID uint64, events chan models.Event) {
+	if _, ok := b.subscribers[userID][events]; !ok {
+		return
+	}
+
+	delete(b.subscribers[userID], events)
+	if len(b.subscribers[userID]) == 0 {
+		delete(b.subscribers, userID)
+	}
+	close(events)
+}
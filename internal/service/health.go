@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/tim3-p/go-ya-diplom/internal/models"
+	"go.uber.org/zap"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+type MigrationVersion func() (version uint, dirty bool, err error)
+
+type AccrualStatusProvider interface {
+	Status() models.AccrualStatus
+}
+
+type Health struct {
+	db               *sql.DB
+	migrationVersion MigrationVersion
+	expectedVersion  uint
+	accrual          AccrualStatusProvider
+	logger           *zap.Logger
+	startedAt        time.Time
+}
+
+func NewHealth(db *sql.DB, migrationVersion MigrationVersion, expectedVersion uint, accrual AccrualStatusProvider, logger *zap.Logger) *Health {
+	return &Health{
+		db:               db,
+		migrationVersion: migrationVersion,
+		expectedVersion:  expectedVersion,
+		accrual:          accrual,
+		logger:           logger,
+		startedAt:        time.Now(),
+	}
+}
+
+// Ready checks the database connection, the schema version and the accrual worker
+// when it runs in the process.
+func (h *Health) Ready(ctx context.Context) models.Readiness {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	checks := []models.Check{
+		h.checkDB(ctx),
+		h.checkMigrations(),
+	}
+	if h.accrual != nil {
+		checks = append(checks, h.checkAccrual())
+	}
+
+	readiness := models.Readiness{Ready: true, Checks: checks}
+	for _, check := range checks {
+		if !check.Healthy {
+			readiness.Ready = false
+		}
+	}
+
+	return readiness
+}
+
+func (h *Health) Status(ctx context.Context) models.SystemStatus {
+	stats := h.db.Stats()
+	version, _, _ := h.migrationVersion()
+
+	status := models.SystemStatus{
+		Readiness:        h.Ready(ctx),
+		StartedAt:        h.startedAt,
+		Uptime:           time.Since(h.startedAt).Round(time.Second).String(),
+		MigrationVersion: version,
+		DB: models.DBStatus{
+			OpenConnections: stats.OpenConnections,
+			InUse:           stats.InUse,
+			Idle:            stats.Idle,
+			WaitCount:       stats.WaitCount,
+		},
+	}
+	if h.accrual != nil {
+		accrual := h.accrual.Status()
+		status.Accrual = &accrual
+	}
+
+	return status
+}
+
+// checkDB pings the database. The probes are not authenticated, so the checks log the errors
+// and report fixed details.
+func (h *Health) checkDB(ctx context.Context) models.Check {
+	check := models.Check{Name: "database", Healthy: true}
+	if err := h.db.PingContext(ctx); err != nil {
+		h.logger.Warn("database is unreachable", zap.Error(err))
+		check.Healthy = false
+		check.Detail = "unreachable"
+	}
+
+	return check
+}
+
+func (h *Health) checkMigrations() models.Check {
+	check := models.Check{Name: "migrations", Healthy: true}
+
+	version, dirty, err := h.migrationVersion()
+	switch {
+	case err != nil:
+		h.logger.Warn("could not read the migration version", zap.Error(err))
+		check.Healthy = false
+		check.Detail = "cannot read version"
+	case dirty:
+		check.Healthy = false
+		check.Detail = fmt.Sprintf("version %d is dirty", version)
+	case version != h.expectedVersion:
+		check.Healthy = false
+		check.Detail = fmt.Sprintf("version %d, expected %d", version, h.expectedVersion)
+	}
+
+	return check
+}
+
+func (h *Health) checkAccrual() models.Check {
+	check := models.Check{Name: "accrual_worker", Healthy: true}
+	if !h.accrual.Status().Running {
+		check.Healthy = false
+		check.Detail = "worker is not running"
+	}
+
+	return check
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/tim3-p/go-ya-diplom/internal/models"
+	"go.uber.org/zap"
+)
+
+const (
+	outboxBatchSize = 100
+	outboxRetention = 7 * 24 * time.Hour
+)
+
+type OutboxStore interface {
+	Dispatch(ctx context.Context, limit int, handle func(event models.Event) error) (int, error)
+	Cleanup(ctx context.Context, before time.Time) error
+}
+
+type OutboxSubscriber interface {
+	HandleEvent(ctx context.Context, event models.Event) error
+}
+
+// OutboxDispatcher relays events stored together with the state changes to the subscribers.
+// An event is marked as processed only after every subscriber handled it, so subscribers
+// may receive the same event again and must tolerate duplicates.
+type OutboxDispatcher struct {
+	store        OutboxStore
+	subscribers  []OutboxSubscriber
+	pollInterval time.Duration
+	logger       *zap.Logger
+	ctx          context.Context
+	cancel       context.CancelFunc
+	done         chan struct{}
+	stopped      chan struct{}
+}
+
+func NewOutboxDispatcher(store OutboxStore, pollInterval time.Duration, logger *zap.Logger) *OutboxDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &OutboxDispatcher{
+		store:        store,
+		pollInterval: pollInterval,
+		logger:       logger,
+		ctx:          ctx,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+	}
+}
+
+// Subscribe registers a subscriber, it must be called before Start.
+func (d *OutboxDispatcher) Subscribe(subscriber OutboxSubscriber) {
+	d.subscribers = append(d.subscribers, subscriber)
+}
+
+func (d *OutboxDispatcher) Start() {
+	go func() {
+		defer close(d.stopped)
+
+		ticker := time.NewTicker(d.pollInterval)
+		defer ticker.Stop()
+
+		lastCleanup := time.Now()
+		for {
+			select {
+			case <-d.done:
+				return
+			case <-ticker.C:
+				d.dispatch()
+
+				if time.Since(lastCleanup) > time.Hour {
+					if err := d.store.Cleanup(d.ctx, time.Now().Add(-outboxRetention)); err != nil {
+						d.logger.Error("could not clean up outbox", zap.Error(err))
+					}
+					lastCleanup = time.Now()
+				}
+			}
+		}
+	}()
+}
+
+// Stop waits for the current batch until ctx is done. Then the batch is rolled back
+// and dispatched again after the restart.
+func (d *OutboxDispatcher) Stop(ctx context.Context) error {
+	close(d.done)
+
+	select {
+	case <-d.stopped:
+		return nil
+	case <-ctx.Done():
+		d.cancel()
+		<-d.stopped
+		return ctx.Err()
+	}
+}
+
+func (d *OutboxDispatcher) dispatch() {
+	for {
+		select {
+		case <-d.done:
+			return
+		default:
+		}
+
+		processed, err := d.store.Dispatch(d.ctx, outboxBatchSize, d.handle)
+		if err != nil {
+			d.logger.Error("could not dispatch outbox events", zap.Error(err))
+			return
+		}
+
+		if processed < outboxBatchSize {
+			return
+		}
+	}
+}
+
+func (d *OutboxDispatcher) handle(event models.Event) error {
+	for _, subscriber := range d.subscribers {
+		if err := subscriber.HandleEvent(d.ctx, event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
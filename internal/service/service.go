@@ -6,13 +6,11 @@ import (
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/hex"
-	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
 
-	"github.com/theplant/luhn"
 	middleware "github.com/tim3-p/go-ya-diplom/internal/middlewares"
+	"github.com/tim3-p/go-ya-diplom/internal/models"
 )
 
 type CookieAuthenticator struct {
@@ -56,14 +54,18 @@ func (a *CookieAuthenticator) SetCookie(w http.ResponseWriter, login string) err
 		return err
 	}
 
+	// The session is shared by the v1 and v2 routes, without a path the cookies would be
+	// scoped to the prefix of the login request.
 	sign := hex.EncodeToString(h.Sum(nil))
 	userIDCookie := &http.Cookie{
 		Name:  "user_id",
 		Value: login,
+		Path:  "/",
 	}
 	signCookie := &http.Cookie{
 		Name:  "sign",
 		Value: sign,
+		Path:  "/",
 	}
 
 	http.SetCookie(w, userIDCookie)
@@ -78,20 +80,8 @@ func Hash(password string) string {
 	return hex.EncodeToString(s.Sum(nil))
 }
 
-func CheckOrderNumber(number string) error {
-	orderInt, err := strconv.Atoi(number)
-	if err != nil {
-		return err
-	}
-
-	if !luhn.Valid(orderInt) {
-		return errors.New("invalid order number")
-	}
-
-	return nil
-}
-
-func LoginFromContext(ctx context.Context) (string, bool) {
-	u, ok := ctx.Value(middleware.ContextLoginKey).(string)
+// UserFromContext returns the user loaded by the Authenticator middleware.
+func UserFromContext(ctx context.Context) (models.User, bool) {
+	u, ok := ctx.Value(middleware.ContextUserKey).(models.User)
 	return u, ok
 }
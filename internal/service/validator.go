@@ -0,0 +1,177 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrEmptyOrderNumber   = errors.New("empty order number")
+	ErrOrderNumberDigits  = errors.New("order number must contain only digits")
+	ErrInvalidOrderNumber = errors.New("invalid order number")
+)
+
+type Validator interface {
+	Validate(number string) error
+}
+
+type ValidatorFunc func(number string) error
+
+func (f ValidatorFunc) Validate(number string) error {
+	return f(number)
+}
+
+// ValidatorChain runs validators in order and returns the first error.
+type ValidatorChain []Validator
+
+func (c ValidatorChain) Validate(number string) error {
+	for _, validator := range c {
+		if err := validator.Validate(number); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Digits accepts non-empty strings of ASCII digits of any length.
+type Digits struct{}
+
+func (Digits) Validate(number string) error {
+	if number == "" {
+		return ErrEmptyOrderNumber
+	}
+
+	for i := 0; i < len(number); i++ {
+		if number[i] < '0' || number[i] > '9' {
+			return ErrOrderNumberDigits
+		}
+	}
+
+	return nil
+}
+
+// Luhn checks the number checksum digit by digit, so the length is not limited by integer types.
+type Luhn struct{}
+
+func (Luhn) Validate(number string) error {
+	if err := (Digits{}).Validate(number); err != nil {
+		return err
+	}
+
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		digit := int(number[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+
+	if sum%10 != 0 {
+		return ErrInvalidOrderNumber
+	}
+
+	return nil
+}
+
+// LengthBounds limits the number of digits, zero means no limit.
+type LengthBounds struct {
+	Min int
+	Max int
+}
+
+func (b LengthBounds) Validate(number string) error {
+	if b.Min > 0 && len(number) < b.Min {
+		return fmt.Errorf("order number must be at least %d digits long", b.Min)
+	}
+
+	if b.Max > 0 && len(number) > b.Max {
+		return fmt.Errorf("order number must be at most %d digits long", b.Max)
+	}
+
+	return nil
+}
+
+// AllowedPrefixes accepts numbers starting with a prefix of any known merchant.
+// An empty set of merchants accepts every number.
+type AllowedPrefixes struct {
+	Merchants map[string][]string
+}
+
+// ParseAllowedPrefixes reads the "merchant=prefix|prefix,merchant=prefix" format.
+func ParseAllowedPrefixes(value string) (AllowedPrefixes, error) {
+	prefixes := AllowedPrefixes{Merchants: map[string][]string{}}
+
+	for _, merchant := range strings.Split(value, ",") {
+		merchant = strings.TrimSpace(merchant)
+		if merchant == "" {
+			continue
+		}
+
+		parts := strings.SplitN(merchant, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return AllowedPrefixes{}, fmt.Errorf("invalid merchant prefixes %q", merchant)
+		}
+
+		for _, prefix := range strings.Split(parts[1], "|") {
+			prefix = strings.TrimSpace(prefix)
+			if err := (Digits{}).Validate(prefix); err != nil {
+				return AllowedPrefixes{}, fmt.Errorf("invalid prefix %q for merchant %s", prefix, parts[0])
+			}
+			prefixes.Merchants[parts[0]] = append(prefixes.Merchants[parts[0]], prefix)
+		}
+	}
+
+	return prefixes, nil
+}
+
+func (p AllowedPrefixes) Validate(number string) error {
+	if len(p.Merchants) == 0 {
+		return nil
+	}
+
+	if p.Merchant(number) == "" {
+		return errors.New("order number does not belong to any known merchant")
+	}
+
+	return nil
+}
+
+// Merchant returns the merchant with the longest prefix of the number or an empty string.
+// Merchants sharing that prefix are ordered by name.
+func (p AllowedPrefixes) Merchant(number string) string {
+	owner, longest := "", -1
+	for merchant, prefixes := range p.Merchants {
+		for _, prefix := range prefixes {
+			if !strings.HasPrefix(number, prefix) {
+				continue
+			}
+			if len(prefix) > longest || len(prefix) == longest && merchant < owner {
+				owner, longest = merchant, len(prefix)
+			}
+		}
+	}
+
+	return owner
+}
+
+// NormalizeOrderNumber strips surrounding whitespace and line breaks from the request body.
+func NormalizeOrderNumber(number string) string {
+	return strings.TrimSpace(number)
+}
+
+func NewOrderNumberValidator(minLength, maxLength int, prefixes AllowedPrefixes) Validator {
+	return ValidatorChain{
+		Digits{},
+		LengthBounds{Min: minLength, Max: maxLength},
+		prefixes,
+		Luhn{},
+	}
+}
@@ -0,0 +1,107 @@
+package service_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tim3-p/go-ya-diplom/internal/service"
+)
+
+func TestOrderNumberValidator(t *testing.T) {
+	prefixes, err := service.ParseAllowedPrefixes("acme=1234, globex=12|99")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unbounded := service.NewOrderNumberValidator(0, 0, service.AllowedPrefixes{})
+	bounded := service.NewOrderNumberValidator(8, 12, service.AllowedPrefixes{})
+	merchants := service.NewOrderNumberValidator(0, 0, prefixes)
+
+	tests := []struct {
+		name      string
+		validator service.Validator
+		number    string
+		valid     bool
+		err       error
+	}{
+		{"longer than uint64", unbounded, "1234567890123456789012345678909", true, nil},
+		{"leading zeros", unbounded, "0012345678903", true, nil},
+		{"zeros only", unbounded, "000", true, nil},
+		{"wrong checksum", unbounded, "12345678900", false, service.ErrInvalidOrderNumber},
+		{"empty", unbounded, "", false, service.ErrEmptyOrderNumber},
+		{"letters", unbounded, "1234567890a", false, service.ErrOrderNumberDigits},
+		{"sign", unbounded, "-12345674", false, service.ErrOrderNumberDigits},
+		{"space", unbounded, "1234 5674", false, service.ErrOrderNumberDigits},
+		{"shortest", bounded, "12345674", true, nil},
+		{"too short", bounded, "1234566", false, nil},
+		{"longest", bounded, "123456789015", true, nil},
+		{"too long", bounded, "1234567890128", false, nil},
+		{"bounds before checksum", bounded, "1234567", false, nil},
+		{"longer prefix", merchants, "123456782", true, nil},
+		{"overlapping shorter prefix", merchants, "1298765437", true, nil},
+		{"second prefix of a merchant", merchants, "999999998", true, nil},
+		{"unknown prefix", merchants, "345678908", false, nil},
+		{"prefix before checksum", merchants, "12345678900", false, service.ErrInvalidOrderNumber},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.validator.Validate(tt.number)
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("Validate(%q) = %v, want nil", tt.number, err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("Validate(%q) = nil, want an error", tt.number)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("Validate(%q) = %v, want %v", tt.number, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestMerchant(t *testing.T) {
+	prefixes := service.AllowedPrefixes{Merchants: map[string][]string{
+		"acme":    {"12", "1234"},
+		"globex":  {"123"},
+		"initech": {"12"},
+	}}
+
+	tests := []struct {
+		number string
+		want   string
+	}{
+		{"12345", "acme"},
+		{"1239", "globex"},
+		{"1299", "acme"},
+		{"9912", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.number, func(t *testing.T) {
+			// The merchants are kept in a map, repeat to cover its iteration orders.
+			for i := 0; i < 50; i++ {
+				if got := prefixes.Merchant(tt.number); got != tt.want {
+					t.Fatalf("Merchant(%q) = %q, want %q", tt.number, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseAllowedPrefixes(t *testing.T) {
+	for _, value := range []string{"acme", "acme=", "=12", "acme=12|x"} {
+		if _, err := service.ParseAllowedPrefixes(value); err == nil {
+			t.Errorf("ParseAllowedPrefixes(%q) accepted an invalid value", value)
+		}
+	}
+
+	prefixes, err := service.ParseAllowedPrefixes("")
+	if err != nil || len(prefixes.Merchants) != 0 {
+		t.Errorf("ParseAllowedPrefixes(\"\") = %v, %v, want no merchants", prefixes, err)
+	}
+}
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/tim3-p/go-ya-diplom/internal/models"
+	"go.uber.org/zap"
+)
+
+const (
+	WebhookSignatureHeader = "X-Gophermart-Signature"
+	WebhookTimestampHeader = "X-Gophermart-Timestamp"
+	WebhookEventHeader     = "X-Gophermart-Event"
+	WebhookDeliveryHeader  = "X-Gophermart-Delivery"
+
+	webhookBatchSize   = 50
+	webhookMaxAttempts = 10
+	webhookBaseBackoff = 5 * time.Second
+	webhookMaxBackoff  = time.Hour
+	webhookTimeout     = 10 * time.Second
+	// webhookLease covers a batch of deliveries sent one after another, each up to the timeout,
+	// so that other replicas do not claim the end of a slow batch again.
+	webhookLease = webhookBatchSize * webhookTimeout
+)
+
+var errWebhookAddress = errors.New("webhook address is not public")
+
+// nonPublicNetworks are not reachable from the internet although net.IP does not classify them so.
+var nonPublicNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+}
+
+type WebhookStore interface {
+	Enqueue(ctx context.Context, userID uint64, event string, payload []byte) error
+	ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	RecordAttempt(ctx context.Context, deliveryID uint64, attempt models.DeliveryAttempt, delivered bool, nextAttemptAt time.Time) error
+}
+
+type webhookPayload struct {
+	Type      string       `json:"type"`
+	CreatedAt string       `json:"created_at"`
+	Data      models.Event `json:"data"`
+}
+
+// Webhooks turns outbox events into signed deliveries to the endpoints registered by users.
+// Deliveries are stored first and sent by a background loop with exponential backoff.
+type Webhooks struct {
+	store        WebhookStore
+	client       *http.Client
+	pollInterval time.Duration
+	logger       *zap.Logger
+	ctx          context.Context
+	cancel       context.CancelFunc
+	done         chan struct{}
+	stopped      chan struct{}
+}
+
+func NewWebhooks(store WebhookStore, pollInterval time.Duration, logger *zap.Logger) *Webhooks {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Webhooks{
+		store:        store,
+		client:       newWebhookClient(),
+		pollInterval: pollInterval,
+		logger:       logger,
+		ctx:          ctx,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+	}
+}
+
+// HandleEvent enqueues deliveries for events that webhooks can subscribe to.
+func (s *Webhooks) HandleEvent(ctx context.Context, event models.Event) error {
+	var webhookEvent string
+	switch {
+	case event.Type == models.EventOrderStatusChanged && event.Status == models.Processed:
+		webhookEvent = models.WebhookOrderProcessed
+	case event.Type == models.EventOrderStatusChanged && event.Status == models.Invalid:
+		webhookEvent = models.WebhookOrderInvalid
+	case event.Type == models.EventWithdrawalCreated:
+		webhookEvent = models.WebhookWithdrawalCreated
+	default:
+		return nil
+	}
+
+	payload, err := json.Marshal(webhookPayload{
+		Type:      webhookEvent,
+		CreatedAt: event.CreatedAt.Format(time.RFC3339),
+		Data:      event,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.store.Enqueue(ctx, event.UserID, webhookEvent, payload)
+}
+
+func (s *Webhooks) Start() {
+	go func() {
+		defer close(s.stopped)
+
+		ticker := time.NewTicker(s.pollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.deliverDue()
+			}
+		}
+	}()
+}
+
+// Stop waits for the current delivery until ctx is done. Then the request is canceled, deliveries
+// left in the batch are claimed again when their lease expires.
+func (s *Webhooks) Stop(ctx context.Context) error {
+	close(s.done)
+
+	select {
+	case <-s.stopped:
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-s.stopped
+		return ctx.Err()
+	}
+}
+
+func (s *Webhooks) deliverDue() {
+	deliveries, err := s.store.ClaimDue(s.ctx, webhookBatchSize, webhookLease)
+	if err != nil {
+		s.logger.Error("could not claim webhook deliveries", zap.Error(err))
+		return
+	}
+
+	for _, delivery := range deliveries {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		attempt := s.send(delivery)
+		if s.ctx.Err() != nil {
+			// Canceled on shutdown, the attempt does not count.
+			return
+		}
+		delivered := attempt.Error == "" && attempt.StatusCode >= 200 && attempt.StatusCode < 300
+
+		var nextAttemptAt time.Time
+		if !delivered && delivery.Attempts+1 < webhookMaxAttempts {
+			nextAttemptAt = time.Now().Add(WebhookBackoff(delivery.Attempts + 1))
+		}
+
+		err := s.store.RecordAttempt(context.Background(), delivery.ID, attempt, delivered, nextAttemptAt)
+		if err != nil {
+			s.logger.Error("could not record webhook delivery", zap.Uint64("delivery_id", delivery.ID), zap.Error(err))
+		}
+	}
+}
+
+func (s *Webhooks) send(delivery models.WebhookDelivery) models.DeliveryAttempt {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	request, err := http.NewRequestWithContext(s.ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return models.DeliveryAttempt{Error: err.Error()}
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookEventHeader, delivery.Event)
+	request.Header.Set(WebhookDeliveryHeader, strconv.FormatUint(delivery.ID, 10))
+	request.Header.Set(WebhookTimestampHeader, timestamp)
+	request.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook([]byte(delivery.Secret), timestamp, delivery.Payload))
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return models.DeliveryAttempt{Error: err.Error()}
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	attempt := models.DeliveryAttempt{StatusCode: response.StatusCode}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		attempt.Error = fmt.Sprintf("unexpected status %s", response.Status)
+	}
+
+	return attempt
+}
+
+// newWebhookClient refuses redirects and connections to addresses that are not public, which
+// a host may resolve to after its webhook was registered.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+				return fmt.Errorf("%w: %s", errWebhookAddress, host)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			// The redirect response is recorded as a failed attempt.
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// IsPublicIP reports whether webhooks may be sent to ip. Loopback, private, link-local,
+// unspecified and multicast addresses are refused.
+func IsPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() {
+		return false
+	}
+
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ValidateWebhookURL checks that rawURL is an absolute http or https URL whose host resolves
+// only to public addresses.
+func ValidateWebhookURL(ctx context.Context, resolver *net.Resolver, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if !IsPublicIP(ip) {
+			return errors.New("url must point to a public address")
+		}
+		return nil
+	}
+
+	addresses, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addresses) == 0 {
+		return fmt.Errorf("url host %q could not be resolved", host)
+	}
+
+	for _, address := range addresses {
+		if !IsPublicIP(address.IP) {
+			return errors.New("url must point to a public address")
+		}
+	}
+
+	return nil
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of "timestamp.payload" so receivers can reject replays.
+func SignWebhook(secret []byte, timestamp string, payload []byte) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func WebhookBackoff(attempt int) time.Duration {
+	backoff := webhookBaseBackoff
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+		if backoff >= webhookMaxBackoff {
+			return webhookMaxBackoff
+		}
+	}
+
+	return backoff
+}
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tim3-p/go-ya-diplom/internal/models"
+)
+
+// Admin holds the queries of the gophermartctl command.
+type Admin struct {
+	db *tracedDB
+}
+
+func CreateAdmin(db *sql.DB) *Admin {
+	return &Admin{
+		db: traced(db),
+	}
+}
+
+func (r *Admin) GetUser(ctx context.Context, login string) (models.UserSummary, error) {
+	sqlStatement := `
+SELECT u.id, u.login, u.balance, u.withdrawn, u.locked_at,
+    (SELECT count(*) FROM "order" WHERE "order".user_id = u.id),
+    (SELECT count(*) FROM withdrawal WHERE withdrawal.user_id = u.id)
+FROM "user" u
+WHERE u.login = $1
+`
+	var user models.UserSummary
+	var lockedAt sql.NullTime
+	row := r.db.QueryRowContext(ctx, sqlStatement, login)
+	err := row.Scan(&user.ID, &user.Login, &user.Balance, &user.Withdrawn, &lockedAt, &user.Orders, &user.Withdrawals)
+	if err != nil {
+		return models.UserSummary{}, err
+	}
+
+	if lockedAt.Valid {
+		user.LockedAt = &lockedAt.Time
+	}
+	return user, nil
+}
+
+// ListOrders returns the oldest orders with the given statuses, of the login when it is not empty.
+func (r *Admin) ListOrders(ctx context.Context, statuses []string, login string, limit int) ([]models.OrderSummary, error) {
+	conditions := []string{"TRUE"}
+	args := []interface{}{}
+	if len(statuses) > 0 {
+		args = append(args, statuses)
+		conditions = append(conditions, fmt.Sprintf(`o.status = ANY($%d)`, len(args)))
+	}
+	if login != "" {
+		args = append(args, login)
+		conditions = append(conditions, fmt.Sprintf(`u.login = $%d`, len(args)))
+	}
+	args = append(args, limit)
+
+	sqlStatement := fmt.Sprintf(`
+SELECT o.number, u.login, o.status, o.accrual, o.poll_attempts, o.created_at, o.last_checked_at
+FROM "order" o
+INNER JOIN "user" u ON u.id = o.user_id
+WHERE %s
+ORDER BY o.created_at, o.id
+LIMIT $%d
+`, strings.Join(conditions, " AND "), len(args))
+
+	rows, err := r.db.QueryContext(ctx, sqlStatement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []models.OrderSummary{}
+	for rows.Next() {
+		var order models.OrderSummary
+		var lastCheckedAt sql.NullTime
+		err := rows.Scan(&order.Number, &order.Login, &order.Status, &order.Accrual, &order.PollAttempts, &order.CreatedAt, &lastCheckedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if lastCheckedAt.Valid {
+			order.LastCheckedAt = &lastCheckedAt.Time
+		}
+		orders = append(orders, order)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// Requeue marks pending orders for the accrual worker, which picks them up on its next scan.
+// Without numbers the orders not checked for longer than stuckAfter are marked.
+func (r *Admin) Requeue(ctx context.Context, numbers []string, stuckAfter time.Duration) ([]string, error) {
+	now := time.Now()
+	args := []interface{}{now, models.New, models.Processing}
+	condition := `COALESCE(last_checked_at, created_at) < $4`
+	if len(numbers) > 0 {
+		args = append(args, numbers)
+		condition = `number = ANY($4)`
+	} else {
+		args = append(args, now.Add(-stuckAfter))
+	}
+
+	sqlStatement := `UPDATE "order" SET requeued_at = $1 WHERE status IN ($2, $3) AND ` + condition + ` RETURNING number`
+	rows, err := r.db.QueryContext(ctx, sqlStatement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requeued := []string{}
+	for rows.Next() {
+		var number string
+		if err := rows.Scan(&number); err != nil {
+			return nil, err
+		}
+		requeued = append(requeued, number)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return requeued, nil
+}
+
+// AdjustBalance changes the balance of the user by amount and records the reason.
+func (r *Admin) AdjustBalance(ctx context.Context, login string, amount float64, reason string) (models.BalanceAdjustment, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.BalanceAdjustment{}, err
+	}
+	defer tx.Rollback()
+
+	adjustment := models.BalanceAdjustment{
+		Login:     login,
+		Amount:    amount,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+
+	var userID uint64
+	row := tx.QueryRowContext(ctx, `UPDATE "user" SET balance = balance + $1 WHERE login = $2 RETURNING id, balance`, amount, login)
+	err = row.Scan(&userID, &adjustment.Balance)
+	if err != nil {
+		return models.BalanceAdjustment{}, translateError(err)
+	}
+
+	sqlStatement := `INSERT INTO balance_adjustment (user_id, amount, reason, created_at) VALUES ($1, $2, $3, $4)`
+	_, err = tx.ExecContext(ctx, sqlStatement, userID, amount, reason, adjustment.CreatedAt)
+	if err != nil {
+		return models.BalanceAdjustment{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return models.BalanceAdjustment{}, err
+	}
+
+	return adjustment, nil
+}
+
+// SetLocked locks or unlocks the account. Locked users can not log in or use their sessions.
+func (r *Admin) SetLocked(ctx context.Context, login string, locked bool) error {
+	var lockedAt sql.NullTime
+	if locked {
+		lockedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+
+	result, err := r.db.ExecContext(ctx, `UPDATE "user" SET locked_at = $1 WHERE login = $2`, lockedAt, login)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// MigrationVersion reads the version table of golang-migrate.
+func (r *Admin) MigrationVersion(ctx context.Context) (models.MigrationVersion, error) {
+	var version models.MigrationVersion
+	row := r.db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`)
+	err := row.Scan(&version.Version, &version.Dirty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return models.MigrationVersion{}, err
+	}
+
+	return version, nil
+}
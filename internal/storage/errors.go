@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+)
+
+const (
+	foreignKeyViolation = "23503"
+	uniqueViolation     = "23505"
+	checkViolation      = "23514"
+)
+
+var (
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrLoginTaken          = errors.New("login has already been taken")
+	ErrOrderExists         = errors.New("order has already been uploaded")
+	ErrConflict            = errors.New("row already exists")
+	ErrReferenceNotFound   = errors.New("referenced row does not exist")
+	ErrConstraint          = errors.New("constraint violation")
+)
+
+// constraintErrors maps constraint names from the migrations to sentinel errors.
+var constraintErrors = map[string]error{
+	"login_unique":         ErrLoginTaken,
+	"number_unique":        ErrOrderExists,
+	"balance_non_negative": ErrInsufficientBalance,
+}
+
+// translateError replaces Postgres constraint violations with sentinel errors, the original
+// error is kept in the chain.
+func translateError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	if sentinel, ok := constraintErrors[pgErr.ConstraintName]; ok {
+		return fmt.Errorf("%w: %v", sentinel, err)
+	}
+
+	switch pgErr.Code {
+	case uniqueViolation:
+		return fmt.Errorf("%w: %v", ErrConflict, err)
+	case foreignKeyViolation:
+		return fmt.Errorf("%w: %v", ErrReferenceNotFound, err)
+	case checkViolation:
+		return fmt.Errorf("%w: %v", ErrConstraint, err)
+	}
+
+	return err
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/tim3-p/go-ya-diplom/internal/models"
+)
+
+const eventsChannel = "gophermart_events"
+
+// eventNotification carries the user of the event, which is not a part of its JSON.
+type eventNotification struct {
+	UserID uint64       `json:"user_id"`
+	Event  models.Event `json:"event"`
+}
+
+// EventChannel relays outbox events to every gophermart process through Postgres LISTEN/NOTIFY,
+// so that event streams get the events dispatched by any replica.
+type EventChannel struct {
+	db *tracedDB
+}
+
+func CreateEventChannel(db *sql.DB) *EventChannel {
+	return &EventChannel{
+		db: traced(db),
+	}
+}
+
+// HandleEvent notifies all listening processes about the event.
+func (c *EventChannel) HandleEvent(ctx context.Context, event models.Event) error {
+	payload, err := json.Marshal(eventNotification{UserID: event.UserID, Event: event})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, eventsChannel, string(payload))
+	return err
+}
+
+// Listen holds a connection of the pool and passes notified events to handle until ctx is done
+// or the connection fails. Events notified while nobody listens are not received.
+func (c *EventChannel) Listen(ctx context.Context, handle func(event models.Event)) error {
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		stdlibConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.New("listening requires the pgx driver")
+		}
+		pgxConn := stdlibConn.Conn()
+
+		if _, err := pgxConn.Exec(ctx, "LISTEN "+eventsChannel); err != nil {
+			return err
+		}
+		defer func() {
+			// A connection interrupted by ctx is closed by pgx and dropped from the pool.
+			if !pgxConn.IsClosed() {
+				pgxConn.Exec(context.Background(), "UNLISTEN "+eventsChannel)
+			}
+		}()
+
+		for {
+			notification, err := pgxConn.WaitForNotification(ctx)
+			if err != nil {
+				return err
+			}
+
+			var message eventNotification
+			if err := json.Unmarshal([]byte(notification.Payload), &message); err != nil {
+				continue
+			}
+			message.Event.UserID = message.UserID
+			handle(message.Event)
+		}
+	})
+}
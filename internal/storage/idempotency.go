@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/tim3-p/go-ya-diplom/internal/models"
+)
+
+type Idempotency struct {
+	db *tracedDB
+}
+
+func CreateIdempotency(db *sql.DB) *Idempotency {
+	return &Idempotency{
+		db: traced(db),
+	}
+}
+
+// reserveAttempts bounds the retries of Reserve when the key is released concurrently.
+const reserveAttempts = 3
+
+// Reserve stores the key for the login if it is not known yet. When the key already exists
+// the stored response is returned with found set to true. A reservation without a response
+// older than lease is taken over, its request is assumed to be lost.
+func (r *Idempotency) Reserve(ctx context.Context, login, key, fingerprint string, lease time.Duration) (models.IdempotentResponse, bool, error) {
+	for attempt := 0; attempt < reserveAttempts; attempt++ {
+		response, found, err := r.reserve(ctx, login, key, fingerprint, lease)
+		if !errors.Is(err, sql.ErrNoRows) {
+			return response, found, err
+		}
+		// The key was released between insert and select, try again.
+	}
+
+	return models.IdempotentResponse{}, false, errors.New("idempotency key is released and reserved concurrently")
+}
+
+func (r *Idempotency) reserve(ctx context.Context, login, key, fingerprint string, lease time.Duration) (models.IdempotentResponse, bool, error) {
+	now := time.Now()
+	insertStatement := `
+INSERT INTO idempotency_key (login, key, fingerprint, created_at) VALUES ($1, $2, $3, $4)
+ON CONFLICT ON CONSTRAINT login_key_unique DO UPDATE
+SET fingerprint = EXCLUDED.fingerprint, created_at = EXCLUDED.created_at
+WHERE idempotency_key.status_code = 0 AND idempotency_key.created_at < $5
+`
+	result, err := r.db.ExecContext(ctx, insertStatement, login, key, fingerprint, now, now.Add(-lease))
+	if err != nil {
+		return models.IdempotentResponse{}, false, err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return models.IdempotentResponse{}, false, err
+	}
+	if inserted > 0 {
+		return models.IdempotentResponse{}, false, nil
+	}
+
+	var response models.IdempotentResponse
+	row := r.db.QueryRowContext(ctx, `SELECT fingerprint, status_code, content_type, body FROM idempotency_key WHERE login = $1 AND key = $2`, login, key)
+	err = row.Scan(&response.Fingerprint, &response.StatusCode, &response.ContentType, &response.Body)
+	if err != nil {
+		return models.IdempotentResponse{}, false, err
+	}
+
+	return response, true, nil
+}
+
+func (r *Idempotency) Save(ctx context.Context, login, key string, response models.IdempotentResponse) error {
+	sqlStatement := `UPDATE idempotency_key SET status_code = $1, content_type = $2, body = $3 WHERE login = $4 AND key = $5`
+	_, err := r.db.ExecContext(ctx, sqlStatement, response.StatusCode, response.ContentType, response.Body, login, key)
+	return err
+}
+
+func (r *Idempotency) Release(ctx context.Context, login, key string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM idempotency_key WHERE login = $1 AND key = $2`, login, key)
+	return err
+}
+
+// Cleanup removes keys created before the given time.
+func (r *Idempotency) Cleanup(ctx context.Context, before time.Time) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM idempotency_key WHERE created_at < $1`, before)
+	return err
+}
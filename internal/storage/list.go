@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tim3-p/go-ya-diplom/internal/models"
+)
+
+// listQuery appends filter conditions, keyset pagination and ordering to the base query.
+// The base query must already contain a WHERE clause with the user_id condition as $1.
+func listQuery(base string, table string, args []interface{}, filter models.ListFilter, withStatus bool) (string, []interface{}) {
+	var sb strings.Builder
+	sb.WriteString(base)
+
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if withStatus && len(filter.Statuses) > 0 {
+		placeholders := make([]string, 0, len(filter.Statuses))
+		for _, status := range filter.Statuses {
+			placeholders = append(placeholders, arg(status))
+		}
+		fmt.Fprintf(&sb, " AND %s.status IN (%s)", table, strings.Join(placeholders, ", "))
+	}
+
+	if !filter.From.IsZero() {
+		fmt.Fprintf(&sb, " AND %s.created_at >= %s", table, arg(filter.From))
+	}
+
+	if !filter.To.IsZero() {
+		fmt.Fprintf(&sb, " AND %s.created_at < %s", table, arg(filter.To))
+	}
+
+	if filter.After != nil {
+		fmt.Fprintf(&sb, " AND (%s.created_at, %s.id) > (%s, %s)", table, table, arg(filter.After.CreatedAt), arg(filter.After.ID))
+	}
+
+	fmt.Fprintf(&sb, " ORDER BY %s.created_at, %s.id", table, table)
+
+	if filter.Limit > 0 {
+		fmt.Fprintf(&sb, " LIMIT %s", arg(filter.Limit))
+	}
+
+	return sb.String(), args
+}
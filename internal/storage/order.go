@@ -3,31 +3,124 @@ package storage
 import (
 	"context"
 	"database/sql"
-	"sort"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/tim3-p/go-ya-diplom/internal/models"
 )
 
 type Order struct {
-	db *sql.DB
+	db *tracedDB
 }
 
 func CreateOrder(db *sql.DB) *Order {
 	return &Order{
-		db: db,
+		db: traced(db),
 	}
 }
 
 func (r *Order) Create(ctx context.Context, order models.Order) error {
-	sqlStatement := `INSERT INTO "order" (number, status, created_at, user_id) VALUES ($1, $2, $3, $4)`
-	_, err := r.db.ExecContext(ctx, sqlStatement, order.Number, order.Status, order.CreatedAt, order.UserID)
-	return err
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var orderID uint64
+	sqlStatement := `INSERT INTO "order" (number, status, created_at, user_id) VALUES ($1, $2, $3, $4) RETURNING id`
+	row := tx.QueryRowContext(ctx, sqlStatement, order.Number, order.Status, order.CreatedAt, order.UserID)
+	err = row.Scan(&orderID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	historyStatement := `INSERT INTO order_status_history (order_id, status, created_at) VALUES ($1, $2, $3)`
+	_, err = tx.ExecContext(ctx, historyStatement, orderID, order.Status, order.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreateBatch inserts the orders of the user in one transaction and reports the result for each number.
+func (r *Order) CreateBatch(ctx context.Context, userID uint64, numbers []string, createdAt time.Time) ([]models.BatchOrderResult, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	insertStatement := `
+INSERT INTO "order" (number, status, created_at, user_id) VALUES ($1, $2, $3, $4)
+ON CONFLICT ON CONSTRAINT number_unique DO NOTHING
+RETURNING id
+`
+	historyStatement := `INSERT INTO order_status_history (order_id, status, created_at) VALUES ($1, $2, $3)`
+
+	results := make([]models.BatchOrderResult, 0, len(numbers))
+	for _, number := range numbers {
+		var orderID uint64
+		err = tx.QueryRowContext(ctx, insertStatement, number, models.New, createdAt, userID).Scan(&orderID)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, historyStatement, orderID, models.New, createdAt)
+			if err != nil {
+				return nil, err
+			}
+
+			results = append(results, models.BatchOrderResult{Number: number, Result: models.BatchAccepted})
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		var ownerID uint64
+		err = tx.QueryRowContext(ctx, `SELECT user_id FROM "order" WHERE number = $1`, number).Scan(&ownerID)
+		if err != nil {
+			return nil, err
+		}
+
+		result := models.BatchAlreadyUploaded
+		if ownerID != userID {
+			result = models.BatchConflict
+		}
+		results = append(results, models.BatchOrderResult{Number: number, Result: result})
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
 }
 
 func (r *Order) GetByUserID(ctx context.Context, userID uint64) ([]models.Order, error) {
+	orders, err := r.List(ctx, userID, models.ListFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(orders) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return orders, nil
+}
+
+func (r *Order) List(ctx context.Context, userID uint64, filter models.ListFilter) ([]models.Order, error) {
 	var orders []models.Order
 
-	rows, err := r.db.QueryContext(ctx, `SELECT id, number, status, accrual, created_at, user_id FROM "order" WHERE user_id = $1`, userID)
+	sqlStatement, args := listQuery(
+		`SELECT id, number, status, accrual, created_at, user_id FROM "order" WHERE "order".user_id = $1`,
+		`"order"`,
+		[]interface{}{userID},
+		filter,
+		true,
+	)
+	rows, err := r.db.QueryContext(ctx, sqlStatement, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -48,14 +141,6 @@ func (r *Order) GetByUserID(ctx context.Context, userID uint64) ([]models.Order,
 		return nil, err
 	}
 
-	if len(orders) == 0 {
-		return nil, sql.ErrNoRows
-	}
-
-	sort.Slice(orders, func(i, j int) bool {
-		return orders[i].CreatedAt.Before(orders[j].CreatedAt)
-	})
-
 	return orders, nil
 }
 
@@ -72,30 +157,208 @@ func (r *Order) GetByNumber(ctx context.Context, number string) (models.Order, e
 	return order, nil
 }
 
-func (r *Order) UpdateAccrual(ctx context.Context, accrual models.Accrual) error {
-	tx, err := r.db.Begin()
+func (r *Order) GetDetails(ctx context.Context, number string) (models.OrderDetails, error) {
+	var details models.OrderDetails
+	var lastCheckedAt sql.NullTime
+
+	sqlStatement := `SELECT id, number, status, accrual, created_at, user_id, poll_attempts, last_checked_at FROM "order" WHERE number = $1`
+	row := r.db.QueryRowContext(ctx, sqlStatement, number)
+	err := row.Scan(
+		&details.Order.ID,
+		&details.Order.Number,
+		&details.Order.Status,
+		&details.Order.Accrual,
+		&details.Order.CreatedAt,
+		&details.Order.UserID,
+		&details.PollAttempts,
+		&lastCheckedAt,
+	)
 	if err != nil {
-		return err
+		return models.OrderDetails{}, err
+	}
+
+	if lastCheckedAt.Valid {
+		details.LastCheckedAt = &lastCheckedAt.Time
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT status, accrual, created_at FROM order_status_history WHERE order_id = $1 ORDER BY created_at, id`, details.Order.ID)
+	if err != nil {
+		return models.OrderDetails{}, err
+	}
+	defer rows.Close()
+
+	details.History = []models.OrderStatusChange{}
+	for rows.Next() {
+		var change models.OrderStatusChange
+		err := rows.Scan(&change.Status, &change.Accrual, &change.CreatedAt)
+		if err != nil {
+			return models.OrderDetails{}, err
+		}
+
+		details.History = append(details.History, change)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return models.OrderDetails{}, err
+	}
+
+	return details, nil
+}
+
+// GetPending returns numbers of orders waiting for the final status from the accrual system.
+func (r *Order) GetPending(ctx context.Context) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT number FROM "order" WHERE status IN ($1, $2) ORDER BY created_at, id`, models.New, models.Processing)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var numbers []string
+	for rows.Next() {
+		var number string
+		if err := rows.Scan(&number); err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, number)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return numbers, nil
+}
+
+// Requeue marks the order for the accrual worker, used when the worker runs in another process.
+func (r *Order) Requeue(ctx context.Context, number string) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE "order" SET requeued_at = $1 WHERE number = $2`, time.Now(), number)
+	return err
+}
+
+// TakeRequeued returns numbers of pending requeued orders and clears the mark.
+func (r *Order) TakeRequeued(ctx context.Context) ([]string, error) {
+	sqlStatement := `UPDATE "order" SET requeued_at = NULL WHERE requeued_at IS NOT NULL AND status IN ($1, $2) RETURNING number`
+	rows, err := r.db.QueryContext(ctx, sqlStatement, models.New, models.Processing)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var numbers []string
+	for rows.Next() {
+		var number string
+		if err := rows.Scan(&number); err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, number)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return numbers, nil
+}
+
+// RecordPoll counts a request to the accrual system for the order.
+func (r *Order) RecordPoll(ctx context.Context, number string) error {
+	sqlStatement := `UPDATE "order" SET poll_attempts = poll_attempts + 1, last_checked_at = $1 WHERE number = $2`
+	_, err := r.db.ExecContext(ctx, sqlStatement, time.Now(), number)
+	return err
+}
+
+// UpdateAccrual stores the response of the accrual system. credited reports that this call
+// added the accrual to the balance, which happens once when the order becomes PROCESSED.
+func (r *Order) UpdateAccrual(ctx context.Context, accrual models.Accrual) (credited bool, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
 	}
 	defer tx.Rollback()
 
-	updateOrderStatement := `UPDATE "order" SET status = $1, accrual = $2 WHERE number = $3`
-	_, err = tx.ExecContext(ctx, updateOrderStatement, accrual.Status, accrual.Accrual, accrual.Order)
+	var orderID, userID uint64
+	var previousStatus string
+	row := tx.QueryRowContext(ctx, `SELECT id, status, user_id FROM "order" WHERE number = $1 FOR UPDATE`, accrual.Order)
+	err = row.Scan(&orderID, &previousStatus, &userID)
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	// A final status has already been credited, repeated updates must not change the balance.
+	if previousStatus == models.Processed || previousStatus == models.Invalid {
+		return false, nil
+	}
+
+	switch accrual.Status {
+	case models.Registered:
+		accrual.Status = models.New
+	case models.New, models.Processing, models.Invalid, models.Processed:
+	default:
+		return false, fmt.Errorf("unknown accrual status %q", accrual.Status)
+	}
+
+	updateOrderStatement := `UPDATE "order" SET status = $1, accrual = $2 WHERE id = $3`
+	_, err = tx.ExecContext(ctx, updateOrderStatement, accrual.Status, accrual.Accrual, orderID)
+	if err != nil {
+		return false, err
 	}
 
-	increaseBalanceStatement := `
+	if previousStatus != accrual.Status {
+		historyStatement := `INSERT INTO order_status_history (order_id, status, accrual, created_at) VALUES ($1, $2, $3, $4)`
+		_, err = tx.ExecContext(ctx, historyStatement, orderID, accrual.Status, accrual.Accrual, time.Now())
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// Only the final PROCESSED response is credited, an accrual sent with an intermediate
+	// status would be added twice otherwise.
+	credited = accrual.Status == models.Processed && accrual.Accrual > 0
+	if credited {
+		increaseBalanceStatement := `
 UPDATE "user"
 SET balance = "user".balance + $1
-FROM "user" as u
-INNER JOIN "order" ON u.id = "order".user_id
-WHERE "order".number = $2
+FROM "order"
+WHERE "user".id = "order".user_id AND "order".id = $2
 `
-	_, err = tx.ExecContext(ctx, increaseBalanceStatement, accrual.Accrual, accrual.Order)
+		_, err = tx.ExecContext(ctx, increaseBalanceStatement, accrual.Accrual, orderID)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if previousStatus != accrual.Status {
+		err = insertOutbox(ctx, tx, models.Event{
+			Type:   models.EventOrderStatusChanged,
+			UserID: userID,
+			Order:  accrual.Order,
+			Status: accrual.Status,
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if credited {
+		err = insertOutbox(ctx, tx, models.Event{
+			Type:   models.EventOrderCredited,
+			UserID: userID,
+			Order:  accrual.Order,
+			Status: accrual.Status,
+			Amount: accrual.Accrual,
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = tx.Commit()
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	return tx.Commit()
+	return credited, nil
 }
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/tim3-p/go-ya-diplom/internal/models"
+)
+
+type Outbox struct {
+	db *tracedDB
+}
+
+func CreateOutbox(db *sql.DB) *Outbox {
+	return &Outbox{
+		db: traced(db),
+	}
+}
+
+// insertOutbox stores the event in the transaction of the state change it describes.
+func insertOutbox(ctx context.Context, tx *tracedTx, event models.Event) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	sqlStatement := `INSERT INTO outbox (user_id, type, payload, created_at) VALUES ($1, $2, $3, $4)`
+	_, err = tx.ExecContext(ctx, sqlStatement, event.UserID, event.Type, payload, event.CreatedAt)
+	return err
+}
+
+// Dispatch passes unprocessed events to handle in insertion order and marks the handled ones.
+// The oldest unprocessed event of a user is locked as the lock of the user: dispatchers skip users
+// locked by another one, and events of a user are handled only after all earlier ones. A failed
+// event is postponed with a backoff together with the later events of its user.
+func (r *Outbox) Dispatch(ctx context.Context, limit int, handle func(event models.Event) error) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	sqlStatement := `
+WITH heads AS (
+    SELECT user_id FROM outbox
+    WHERE processed_at IS NULL AND (next_attempt_at IS NULL OR next_attempt_at <= $1)
+      AND NOT EXISTS (
+        SELECT 1 FROM outbox earlier
+        WHERE earlier.user_id = outbox.user_id AND earlier.processed_at IS NULL AND earlier.id < outbox.id
+      )
+    ORDER BY id
+    LIMIT $2
+    FOR UPDATE SKIP LOCKED
+)
+SELECT id, user_id, type, payload, created_at, attempts FROM outbox
+WHERE processed_at IS NULL AND user_id IN (SELECT user_id FROM heads)
+ORDER BY id
+LIMIT $2
+`
+	rows, err := tx.QueryContext(ctx, sqlStatement, time.Now(), limit)
+	if err != nil {
+		return 0, err
+	}
+
+	var events []models.Event
+	attempts := map[uint64]int{}
+	for rows.Next() {
+		var event models.Event
+		var payload []byte
+		var attempt int
+		err := rows.Scan(&event.ID, &event.UserID, &event.Type, &payload, &event.CreatedAt, &attempt)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		id, userID, createdAt := event.ID, event.UserID, event.CreatedAt
+		if err := json.Unmarshal(payload, &event); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		event.ID, event.UserID, event.CreatedAt = id, userID, createdAt
+
+		events = append(events, event)
+		attempts[event.ID] = attempt
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return 0, err
+	}
+
+	var handleErr error
+	processed := 0
+	failed := map[uint64]bool{}
+	for _, event := range events {
+		if failed[event.UserID] {
+			continue
+		}
+
+		if err := handle(event); err != nil {
+			failed[event.UserID] = true
+			handleErr = err
+
+			retryAt := time.Now().Add(outboxBackoff(attempts[event.ID] + 1))
+			_, err = tx.ExecContext(ctx, `UPDATE outbox SET attempts = attempts + 1, next_attempt_at = $1 WHERE id = $2`, retryAt, event.ID)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE outbox SET processed_at = $1 WHERE id = $2`, time.Now(), event.ID)
+		if err != nil {
+			return 0, err
+		}
+		processed++
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return processed, handleErr
+}
+
+// outboxBackoff postpones a failed event by a second per attempt, up to a minute.
+func outboxBackoff(attempt int) time.Duration {
+	backoff := time.Duration(attempt) * time.Second
+	if backoff > time.Minute {
+		return time.Minute
+	}
+	return backoff
+}
+
+// Cleanup removes events processed before the given time.
+func (r *Outbox) Cleanup(ctx context.Context, before time.Time) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM outbox WHERE processed_at < $1`, before)
+	return err
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/tim3-p/go-ya-diplom/internal/storage")
+
+// tracedDB starts a span for every query. Spans of queries returning rows end when the query
+// returns, reading the rows is not included.
+type tracedDB struct {
+	*sql.DB
+}
+
+func traced(db *sql.DB) *tracedDB {
+	return &tracedDB{DB: db}
+}
+
+func (db *tracedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	result, err := db.DB.ExecContext(ctx, query, args...)
+	endQuerySpan(span, err)
+	return result, err
+}
+
+func (db *tracedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	endQuerySpan(span, err)
+	return rows, err
+}
+
+func (db *tracedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := startQuerySpan(ctx, query)
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	endQuerySpan(span, row.Err())
+	return row
+}
+
+func (db *tracedDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*tracedTx, error) {
+	ctx, span := tracer.Start(ctx, "db.transaction", trace.WithSpanKind(trace.SpanKindClient))
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		endQuerySpan(span, err)
+		return nil, err
+	}
+
+	return &tracedTx{Tx: tx, ctx: ctx, span: span}, nil
+}
+
+// tracedTx puts the spans of its queries under the span of the transaction,
+// which ends on commit or rollback.
+type tracedTx struct {
+	*sql.Tx
+	ctx  context.Context
+	span trace.Span
+	done bool
+}
+
+func (tx *tracedTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	_, span := startQuerySpan(tx.ctx, query)
+	result, err := tx.Tx.ExecContext(ctx, query, args...)
+	endQuerySpan(span, err)
+	return result, err
+}
+
+func (tx *tracedTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	_, span := startQuerySpan(tx.ctx, query)
+	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	endQuerySpan(span, err)
+	return rows, err
+}
+
+func (tx *tracedTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	_, span := startQuerySpan(tx.ctx, query)
+	row := tx.Tx.QueryRowContext(ctx, query, args...)
+	endQuerySpan(span, row.Err())
+	return row
+}
+
+func (tx *tracedTx) Commit() error {
+	err := tx.Tx.Commit()
+	tx.end(err)
+	return err
+}
+
+func (tx *tracedTx) Rollback() error {
+	err := tx.Tx.Rollback()
+	if errors.Is(err, sql.ErrTxDone) {
+		tx.end(nil)
+	} else {
+		tx.end(err)
+	}
+	return err
+}
+
+func (tx *tracedTx) end(err error) {
+	if tx.done {
+		return
+	}
+	tx.done = true
+	endQuerySpan(tx.span, err)
+}
+
+func startQuerySpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	query = strings.TrimSpace(query)
+	operation := query
+	if i := strings.IndexAny(query, " \n"); i > 0 {
+		operation = query[:i]
+	}
+
+	return tracer.Start(ctx, "db."+strings.ToLower(operation),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", query),
+		),
+	)
+}
+
+func endQuerySpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
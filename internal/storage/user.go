@@ -3,34 +3,117 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strings"
 
 	"github.com/tim3-p/go-ya-diplom/internal/models"
 )
 
 type User struct {
-	db *sql.DB
+	db *tracedDB
 }
 
 func CreateUser(db *sql.DB) *User {
 	return &User{
-		db: db,
+		db: traced(db),
 	}
 }
 
 func (r *User) Create(ctx context.Context, user models.User) error {
 	sqlStatement := `INSERT INTO "user" (login, password_hash) VALUES ($1, $2)`
 	_, err := r.db.ExecContext(ctx, sqlStatement, user.Login, user.PasswordHash)
-	return err
+	return translateError(err)
 }
 
 func (r *User) GetByLogin(ctx context.Context, login string) (models.User, error) {
 	var user models.User
 
-	row := r.db.QueryRowContext(ctx, `SELECT id, login, password_hash, balance, withdrawn FROM "user" WHERE login = $1`, login)
-	err := row.Scan(&user.ID, &user.Login, &user.PasswordHash, &user.Balance, &user.Withdrawn)
+	row := r.db.QueryRowContext(ctx, `SELECT id, login, password_hash, balance, withdrawn, locked_at IS NOT NULL FROM "user" WHERE login = $1`, login)
+	err := row.Scan(&user.ID, &user.Login, &user.PasswordHash, &user.Balance, &user.Withdrawn, &user.Locked)
 	if err != nil {
 		return models.User{}, err
 	}
 
 	return user, nil
 }
+
+// statementQuery merges the accruals of processed orders, dated when they became PROCESSED, the
+// withdrawals and the adjustments made by gophermartctl. The running balance is summed over all
+// entries of the user before GetStatement cuts the page, entry IDs are compared bytewise.
+const statementQuery = `
+WITH entry AS (
+    SELECT 'o' || o.id AS id, $3::text AS type, o.number AS "order", '' AS reason, o.accrual AS amount, COALESCE(
+        (SELECT min(h.created_at) FROM order_status_history h WHERE h.order_id = o.id AND h.status = $2),
+        o.created_at
+    ) AS created_at
+    FROM "order" o
+    WHERE o.user_id = $1 AND o.status = $2 AND o.accrual > 0
+    UNION ALL
+    SELECT 'w' || w.id, $4::text, w."order", '', w.sum, w.created_at
+    FROM withdrawal w
+    WHERE w.user_id = $1
+    UNION ALL
+    SELECT 'a' || a.id, $5::text, '', a.reason, a.amount, a.created_at
+    FROM balance_adjustment a
+    WHERE a.user_id = $1
+), statement AS (
+    SELECT id COLLATE "C" AS id, type, "order", reason, amount, created_at,
+        sum(CASE WHEN type = $4::text THEN -amount ELSE amount END) OVER (ORDER BY created_at, id COLLATE "C") AS balance
+    FROM entry
+)
+SELECT id, type, "order", reason, amount, balance, created_at FROM statement WHERE TRUE`
+
+// GetStatement returns a page of the balance changes of the user with keyset pagination on
+// (created_at, id), as listQuery does for a single table.
+func (r *User) GetStatement(ctx context.Context, userID uint64, filter models.StatementFilter) ([]models.StatementEntry, error) {
+	args := []interface{}{userID, models.Processed, models.Credit, models.Debit, models.Adjustment}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(statementQuery)
+
+	if !filter.From.IsZero() {
+		fmt.Fprintf(&sb, " AND created_at >= %s", arg(filter.From))
+	}
+
+	if !filter.To.IsZero() {
+		fmt.Fprintf(&sb, " AND created_at < %s", arg(filter.To))
+	}
+
+	if filter.After != nil {
+		fmt.Fprintf(&sb, " AND (created_at, id) > (%s, %s)", arg(filter.After.CreatedAt), arg(filter.After.ID))
+	}
+
+	sb.WriteString(" ORDER BY created_at, id")
+
+	if filter.Limit > 0 {
+		fmt.Fprintf(&sb, " LIMIT %s", arg(filter.Limit))
+	}
+
+	rows, err := r.db.QueryContext(ctx, sb.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.StatementEntry{}
+	for rows.Next() {
+		var entry models.StatementEntry
+		err := rows.Scan(&entry.ID, &entry.Type, &entry.Order, &entry.Reason, &entry.Amount, &entry.Balance, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/tim3-p/go-ya-diplom/internal/models"
+	"github.com/tim3-p/go-ya-diplom/internal/secrets"
+)
+
+// Webhook stores the signing secrets encrypted with secrets.
+type Webhook struct {
+	db      *tracedDB
+	secrets *secrets.Box
+}
+
+func CreateWebhook(db *sql.DB, secrets *secrets.Box) *Webhook {
+	return &Webhook{
+		db:      traced(db),
+		secrets: secrets,
+	}
+}
+
+func (r *Webhook) Create(ctx context.Context, webhook models.Webhook) (models.Webhook, error) {
+	secret, err := r.secrets.Seal(webhook.Secret)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+
+	sqlStatement := `INSERT INTO webhook (url, secret, events, created_at, user_id) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	row := r.db.QueryRowContext(ctx, sqlStatement, webhook.URL, secret, strings.Join(webhook.Events, ","), webhook.CreatedAt, webhook.UserID)
+	err = row.Scan(&webhook.ID)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+
+	return webhook, nil
+}
+
+func (r *Webhook) GetByUserID(ctx context.Context, userID uint64) ([]models.Webhook, error) {
+	webhooks := []models.Webhook{}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT id, url, events, created_at, user_id FROM webhook WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var webhook models.Webhook
+		var events string
+		err := rows.Scan(&webhook.ID, &webhook.URL, &events, &webhook.CreatedAt, &webhook.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		webhook.Events = strings.Split(events, ",")
+		webhooks = append(webhooks, webhook)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// Delete removes the webhook of the user, its deliveries are removed by the foreign key.
+func (r *Webhook) Delete(ctx context.Context, userID, webhookID uint64) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM webhook WHERE id = $1 AND user_id = $2`, webhookID, userID)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Enqueue creates a pending delivery for every webhook of the user subscribed to the event.
+func (r *Webhook) Enqueue(ctx context.Context, userID uint64, event string, payload []byte) error {
+	sqlStatement := `
+INSERT INTO webhook_delivery (webhook_id, event, payload, status, next_attempt_at, created_at)
+SELECT id, $1, $2, $3, $4, $4 FROM webhook
+WHERE user_id = $5 AND $1 = ANY(string_to_array(events, ','))
+`
+	_, err := r.db.ExecContext(ctx, sqlStatement, event, payload, models.DeliveryPending, time.Now(), userID)
+	return err
+}
+
+// ClaimDue returns pending deliveries whose attempt is due and postpones them by lease,
+// so that other dispatchers do not pick them up while they are being sent. Deliveries whose secret
+// can not be decrypted are marked failed and left out.
+func (r *Webhook) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	sqlStatement := `
+UPDATE webhook_delivery
+SET next_attempt_at = $1
+FROM webhook
+WHERE webhook.id = webhook_delivery.webhook_id AND webhook_delivery.id IN (
+    SELECT id FROM webhook_delivery
+    WHERE status = $2 AND next_attempt_at <= $3
+    ORDER BY next_attempt_at
+    LIMIT $4
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING webhook_delivery.id, webhook_delivery.webhook_id, webhook_delivery.event, webhook_delivery.payload,
+    webhook_delivery.attempts, webhook_delivery.created_at, webhook.url, webhook.secret
+`
+	now := time.Now()
+	rows, err := r.db.QueryContext(ctx, sqlStatement, now.Add(lease), models.DeliveryPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	var undecryptable []uint64
+	for rows.Next() {
+		delivery := models.WebhookDelivery{Status: models.DeliveryPending}
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.Event,
+			&delivery.Payload,
+			&delivery.Attempts,
+			&delivery.CreatedAt,
+			&delivery.URL,
+			&delivery.Secret,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		delivery.Secret, err = r.secrets.Open(delivery.Secret)
+		if err != nil {
+			undecryptable = append(undecryptable, delivery.ID)
+			continue
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for _, id := range undecryptable {
+		attempt := models.DeliveryAttempt{Error: "webhook secret can not be decrypted"}
+		if err := r.RecordAttempt(ctx, id, attempt, false, time.Time{}); err != nil {
+			return nil, err
+		}
+	}
+
+	return deliveries, nil
+}
+
+// RecordAttempt stores the result of a delivery attempt. A zero nextAttemptAt marks an unsuccessful
+// delivery as failed for good.
+func (r *Webhook) RecordAttempt(ctx context.Context, deliveryID uint64, attempt models.DeliveryAttempt, delivered bool, nextAttemptAt time.Time) error {
+	status := models.DeliveryPending
+	var deliveredAt sql.NullTime
+	switch {
+	case delivered:
+		status = models.DeliveryDelivered
+		deliveredAt = sql.NullTime{Time: time.Now(), Valid: true}
+	case nextAttemptAt.IsZero():
+		status = models.DeliveryFailed
+	}
+
+	sqlStatement := `
+UPDATE webhook_delivery
+SET status = $1, attempts = attempts + 1, last_status_code = $2, last_error = $3, next_attempt_at = $4, delivered_at = $5
+WHERE id = $6
+`
+	_, err := r.db.ExecContext(ctx, sqlStatement, status, attempt.StatusCode, attempt.Error, nextAttemptAt, deliveredAt, deliveryID)
+	return err
+}
+
+func (r *Webhook) GetDeliveries(ctx context.Context, userID, webhookID uint64) ([]models.WebhookDelivery, error) {
+	sqlStatement := `
+SELECT d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.last_status_code, d.last_error, d.created_at, d.delivered_at
+FROM webhook_delivery d
+INNER JOIN webhook ON webhook.id = d.webhook_id
+WHERE webhook.id = $1 AND webhook.user_id = $2
+ORDER BY d.created_at DESC, d.id DESC
+LIMIT 100
+`
+	rows, err := r.db.QueryContext(ctx, sqlStatement, webhookID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		var deliveredAt sql.NullTime
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.Event,
+			&delivery.Payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.LastStatusCode,
+			&delivery.LastError,
+			&delivery.CreatedAt,
+			&deliveredAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if deliveredAt.Valid {
+			delivery.DeliveredAt = &deliveredAt.Time
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// Redeliver schedules the delivery of the user to be sent again right away, with a new
+// set of attempts.
+func (r *Webhook) Redeliver(ctx context.Context, userID, webhookID, deliveryID uint64) error {
+	sqlStatement := `
+UPDATE webhook_delivery
+SET status = $1, next_attempt_at = $2, attempts = 0
+FROM webhook
+WHERE webhook.id = webhook_delivery.webhook_id AND webhook_delivery.id = $3 AND webhook.id = $4 AND webhook.user_id = $5
+`
+	result, err := r.db.ExecContext(ctx, sqlStatement, models.DeliveryPending, time.Now(), deliveryID, webhookID, userID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -3,57 +3,56 @@ package storage
 import (
 	"context"
 	"database/sql"
-	"errors"
-	"sort"
 
 	"github.com/tim3-p/go-ya-diplom/internal/models"
 )
 
-var (
-	ErrInsufficientBalance = errors.New("insufficient balance")
-)
-
 type Withdrawal struct {
-	db *sql.DB
+	db *tracedDB
 }
 
 func CreateWithdrawal(db *sql.DB) *Withdrawal {
 	return &Withdrawal{
-		db: db,
+		db: traced(db),
 	}
 }
 
 func (r *Withdrawal) Create(ctx context.Context, withdrawal models.Withdrawal) error {
-	tx, err := r.db.Begin()
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-			return
-		}
-	}()
+	defer tx.Rollback()
 
-	var balance float64
-	row := tx.QueryRowContext(ctx, `SELECT balance FROM "user" WHERE id = $1`, withdrawal.UserID)
-	err = row.Scan(&balance)
+	// The balance_non_negative constraint rejects the update when the balance is too low,
+	// so concurrent withdrawals can not overdraw the account.
+	updateBalanceStatement := `UPDATE "user" SET balance = balance - $1, withdrawn = withdrawn + $1 WHERE id = $2`
+	result, err := tx.ExecContext(ctx, updateBalanceStatement, withdrawal.Sum, withdrawal.UserID)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
-	if balance < withdrawal.Sum {
-		return ErrInsufficientBalance
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return sql.ErrNoRows
 	}
 
 	createWithdrawalStatement := `INSERT INTO withdrawal ("order", sum, created_at, user_id) VALUES ($1, $2, $3, $4)`
 	_, err = tx.ExecContext(ctx, createWithdrawalStatement, withdrawal.Order, withdrawal.Sum, withdrawal.CreatedAt, withdrawal.UserID)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
-	updateBalanceStatement := `UPDATE "user" SET balance = balance - $1, withdrawn = withdrawn + $1 WHERE id = $2`
-	_, err = tx.ExecContext(ctx, updateBalanceStatement, withdrawal.Sum, withdrawal.UserID)
+	err = insertOutbox(ctx, tx, models.Event{
+		Type:      models.EventWithdrawalCreated,
+		UserID:    withdrawal.UserID,
+		Order:     withdrawal.Order,
+		Amount:    withdrawal.Sum,
+		CreatedAt: withdrawal.CreatedAt,
+	})
 	if err != nil {
 		return err
 	}
@@ -62,9 +61,29 @@ func (r *Withdrawal) Create(ctx context.Context, withdrawal models.Withdrawal) e
 }
 
 func (r *Withdrawal) GetByUserID(ctx context.Context, userID uint64) ([]models.Withdrawal, error) {
+	withdrawals, err := r.List(ctx, userID, models.ListFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(withdrawals) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return withdrawals, nil
+}
+
+func (r *Withdrawal) List(ctx context.Context, userID uint64, filter models.ListFilter) ([]models.Withdrawal, error) {
 	var withdrawals []models.Withdrawal
 
-	rows, err := r.db.QueryContext(ctx, `SELECT id, "order", sum, created_at, user_id FROM withdrawal WHERE user_id = $1`, userID)
+	sqlStatement, args := listQuery(
+		`SELECT id, "order", sum, created_at, user_id FROM withdrawal WHERE withdrawal.user_id = $1`,
+		`withdrawal`,
+		[]interface{}{userID},
+		filter,
+		false,
+	)
+	rows, err := r.db.QueryContext(ctx, sqlStatement, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -85,13 +104,5 @@ func (r *Withdrawal) GetByUserID(ctx context.Context, userID uint64) ([]models.W
 		return nil, err
 	}
 
-	if len(withdrawals) == 0 {
-		return nil, sql.ErrNoRows
-	}
-
-	sort.Slice(withdrawals, func(i, j int) bool {
-		return withdrawals[i].CreatedAt.Before(withdrawals[j].CreatedAt)
-	})
-
 	return withdrawals, nil
 }
@@ -0,0 +1,158 @@
+// Package validation checks struct fields against rules declared in the validate tag:
+//
+//	Login string `json:"login" validate:"required,min=3,max=64,charset=login"`
+//
+// Rules are required, min and max (length of strings, value of numbers), gt (numbers),
+// charset=login and password.
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// Errors lists all invalid fields of a struct.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Field + ": " + err.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Struct validates the fields of the struct v points to. It returns Errors when some fields are invalid.
+func Struct(v interface{}) error {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return fmt.Errorf("validation: %T is not a struct", v)
+	}
+
+	var errs Errors
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+
+		name := fieldName(field)
+		for _, rule := range strings.Split(tag, ",") {
+			message, err := check(value.Field(i), rule)
+			if err != nil {
+				return fmt.Errorf("validation: field %s: %w", field.Name, err)
+			}
+			if message != "" {
+				errs = append(errs, FieldError{Field: name, Message: message})
+				break
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func fieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// check returns the message for a broken rule or an empty string.
+func check(value reflect.Value, rule string) (string, error) {
+	name, arg := rule, ""
+	if i := strings.Index(rule, "="); i >= 0 {
+		name, arg = rule[:i], rule[i+1:]
+	}
+
+	switch name {
+	case "required":
+		if value.IsZero() {
+			return "is required", nil
+		}
+	case "min", "max", "gt":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s argument %q", name, arg)
+		}
+		return checkBound(value, name, limit)
+	case "charset":
+		if arg != "login" {
+			return "", fmt.Errorf("unknown charset %q", arg)
+		}
+		for _, r := range value.String() {
+			if !(r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("._-", r))) {
+				return "may contain only latin letters, digits, dots, underscores and hyphens", nil
+			}
+		}
+	case "password":
+		var letter, digit bool
+		for _, r := range value.String() {
+			letter = letter || unicode.IsLetter(r)
+			digit = digit || unicode.IsDigit(r)
+		}
+		if !letter || !digit {
+			return "must contain letters and digits", nil
+		}
+	default:
+		return "", fmt.Errorf("unknown rule %q", name)
+	}
+
+	return "", nil
+}
+
+func checkBound(value reflect.Value, rule string, limit float64) (string, error) {
+	var actual float64
+	unit := ""
+	switch value.Kind() {
+	case reflect.String:
+		actual, unit = float64(utf8.RuneCountInString(value.String())), "characters"
+	case reflect.Slice, reflect.Map:
+		actual, unit = float64(value.Len()), "items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		actual = float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		actual = float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		actual = value.Float()
+	default:
+		return "", fmt.Errorf("rule %s does not support %s", rule, value.Kind())
+	}
+
+	limitText := strconv.FormatFloat(limit, 'f', -1, 64)
+	switch {
+	case rule == "min" && actual < limit:
+		return boundMessage("at least", limitText, unit), nil
+	case rule == "max" && actual > limit:
+		return boundMessage("at most", limitText, unit), nil
+	case rule == "gt" && actual <= limit:
+		return "must be greater than " + limitText, nil
+	}
+
+	return "", nil
+}
+
+func boundMessage(bound, limit, unit string) string {
+	switch unit {
+	case "characters":
+		return "must be " + bound + " " + limit + " characters long"
+	case "items":
+		return "must contain " + bound + " " + limit + " items"
+	}
+	return "must be " + bound + " " + limit
+}
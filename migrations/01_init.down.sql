@@ -1,3 +1,3 @@
-DROP TABLE "user";
+DROP TABLE withdrawal;
 DROP TABLE "order";
-DROP TABLE withdrawal;
\ No newline at end of file
+DROP TABLE "user";
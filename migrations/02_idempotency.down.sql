@@ -0,0 +1 @@
+DROP TABLE idempotency_key;
\ No newline at end of file
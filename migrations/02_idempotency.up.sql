@@ -0,0 +1,12 @@
+CREATE TABLE idempotency_key
+(
+    id           bigserial primary key,
+    login        varchar(255) not null,
+    key          varchar(255) not null,
+    fingerprint  varchar(64)  not null,
+    status_code  integer      not null default 0,
+    content_type varchar(255) not null default '',
+    body         bytea,
+    created_at   Timestamp    not null,
+    CONSTRAINT login_key_unique UNIQUE (login, key)
+);
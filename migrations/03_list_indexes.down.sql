@@ -0,0 +1,2 @@
+DROP INDEX withdrawal_user_id_created_at_idx;
+DROP INDEX order_user_id_created_at_idx;
\ No newline at end of file
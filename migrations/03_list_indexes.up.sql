@@ -0,0 +1,3 @@
+CREATE INDEX order_user_id_created_at_idx ON "order" (user_id, created_at, id);
+
+CREATE INDEX withdrawal_user_id_created_at_idx ON withdrawal (user_id, created_at, id);
@@ -0,0 +1,2 @@
+DROP TABLE order_status_history;
+ALTER TABLE "order" DROP COLUMN last_checked_at, DROP COLUMN poll_attempts;
\ No newline at end of file
@@ -0,0 +1,14 @@
+ALTER TABLE "order"
+    ADD COLUMN poll_attempts   integer not null default 0,
+    ADD COLUMN last_checked_at Timestamp;
+
+CREATE TABLE order_status_history
+(
+    id         bigserial primary key,
+    order_id   bigint         not null,
+    status     varchar(10)    not null,
+    accrual    numeric(12, 2) not null default 0,
+    created_at Timestamp      not null
+);
+
+CREATE INDEX order_status_history_order_id_idx ON order_status_history (order_id, created_at);
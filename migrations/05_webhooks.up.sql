@@ -0,0 +1,29 @@
+CREATE TABLE webhook
+(
+    id         bigserial primary key,
+    url        varchar(2048) not null,
+    secret     varchar(255)  not null,
+    events     varchar(255)  not null,
+    created_at Timestamp     not null,
+    user_id    bigint        not null
+);
+
+CREATE INDEX webhook_user_id_idx ON webhook (user_id);
+
+CREATE TABLE webhook_delivery
+(
+    id               bigserial primary key,
+    webhook_id       bigint      not null,
+    event            varchar(64) not null,
+    payload          bytea       not null,
+    status           varchar(10) not null,
+    attempts         integer     not null default 0,
+    last_status_code integer     not null default 0,
+    last_error       text        not null default '',
+    next_attempt_at  Timestamp   not null,
+    created_at       Timestamp   not null,
+    delivered_at     Timestamp
+);
+
+CREATE INDEX webhook_delivery_webhook_id_idx ON webhook_delivery (webhook_id, created_at);
+CREATE INDEX webhook_delivery_due_idx ON webhook_delivery (next_attempt_at) WHERE status = 'PENDING';
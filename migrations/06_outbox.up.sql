@@ -0,0 +1,11 @@
+CREATE TABLE outbox
+(
+    id           bigserial primary key,
+    user_id      bigint      not null,
+    type         varchar(64) not null,
+    payload      bytea       not null,
+    created_at   Timestamp   not null,
+    processed_at Timestamp
+);
+
+CREATE INDEX outbox_unprocessed_idx ON outbox (id) WHERE processed_at IS NULL;
@@ -0,0 +1 @@
+ALTER TABLE "user" DROP CONSTRAINT balance_non_negative;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE "user"
+    ADD CONSTRAINT balance_non_negative CHECK (balance >= 0);
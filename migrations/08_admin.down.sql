@@ -0,0 +1,3 @@
+DROP TABLE balance_adjustment;
+ALTER TABLE "order" DROP COLUMN requeued_at;
+ALTER TABLE "user" DROP COLUMN locked_at;
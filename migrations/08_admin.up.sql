@@ -0,0 +1,18 @@
+ALTER TABLE "user"
+    ADD COLUMN locked_at Timestamp;
+
+ALTER TABLE "order"
+    ADD COLUMN requeued_at Timestamp;
+
+CREATE INDEX order_requeued_idx ON "order" (requeued_at) WHERE requeued_at IS NOT NULL;
+
+CREATE TABLE balance_adjustment
+(
+    id         bigserial primary key,
+    user_id    bigint         not null,
+    amount     numeric(12, 2) not null,
+    reason     text           not null,
+    created_at Timestamp      not null
+);
+
+CREATE INDEX balance_adjustment_user_id_idx ON balance_adjustment (user_id, created_at);
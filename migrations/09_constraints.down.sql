@@ -0,0 +1,48 @@
+ALTER TABLE balance_adjustment
+    DROP CONSTRAINT balance_adjustment_user_fk,
+    ALTER COLUMN created_at TYPE Timestamp USING created_at AT TIME ZONE 'UTC';
+
+DROP INDEX outbox_user_id_idx;
+
+ALTER TABLE outbox
+    DROP CONSTRAINT outbox_user_fk,
+    ALTER COLUMN processed_at TYPE Timestamp USING processed_at AT TIME ZONE 'UTC',
+    ALTER COLUMN created_at TYPE Timestamp USING created_at AT TIME ZONE 'UTC';
+
+ALTER TABLE webhook_delivery
+    DROP CONSTRAINT webhook_delivery_attempts_non_negative,
+    DROP CONSTRAINT webhook_delivery_status_check,
+    DROP CONSTRAINT webhook_delivery_webhook_fk,
+    ALTER COLUMN delivered_at TYPE Timestamp USING delivered_at AT TIME ZONE 'UTC',
+    ALTER COLUMN created_at TYPE Timestamp USING created_at AT TIME ZONE 'UTC',
+    ALTER COLUMN next_attempt_at TYPE Timestamp USING next_attempt_at AT TIME ZONE 'UTC';
+
+ALTER TABLE webhook
+    DROP CONSTRAINT webhook_user_fk,
+    ALTER COLUMN created_at TYPE Timestamp USING created_at AT TIME ZONE 'UTC';
+
+ALTER TABLE order_status_history
+    DROP CONSTRAINT order_status_history_accrual_non_negative,
+    DROP CONSTRAINT order_status_history_status_check,
+    DROP CONSTRAINT order_status_history_order_fk,
+    ALTER COLUMN created_at TYPE Timestamp USING created_at AT TIME ZONE 'UTC';
+
+ALTER TABLE idempotency_key
+    ALTER COLUMN created_at TYPE Timestamp USING created_at AT TIME ZONE 'UTC';
+
+ALTER TABLE withdrawal
+    DROP CONSTRAINT withdrawal_sum_non_negative,
+    DROP CONSTRAINT withdrawal_user_fk,
+    ALTER COLUMN created_at TYPE Timestamp USING created_at AT TIME ZONE 'UTC';
+
+ALTER TABLE "order"
+    DROP CONSTRAINT order_accrual_non_negative,
+    DROP CONSTRAINT order_status_check,
+    DROP CONSTRAINT order_user_fk,
+    ALTER COLUMN requeued_at TYPE Timestamp USING requeued_at AT TIME ZONE 'UTC',
+    ALTER COLUMN last_checked_at TYPE Timestamp USING last_checked_at AT TIME ZONE 'UTC',
+    ALTER COLUMN created_at TYPE Timestamp USING created_at AT TIME ZONE 'UTC';
+
+ALTER TABLE "user"
+    DROP CONSTRAINT withdrawn_non_negative,
+    ALTER COLUMN locked_at TYPE Timestamp USING locked_at AT TIME ZONE 'UTC';
@@ -0,0 +1,56 @@
+-- REGISTERED is a status of the accrual system, which was stored unchanged before.
+UPDATE "order" SET status = 'NEW' WHERE status = 'REGISTERED';
+UPDATE order_status_history SET status = 'NEW' WHERE status = 'REGISTERED';
+
+-- Existing timestamps were written in the server time zone, which is expected to be UTC.
+ALTER TABLE "user"
+    ALTER COLUMN locked_at TYPE timestamptz USING locked_at AT TIME ZONE 'UTC',
+    ADD CONSTRAINT withdrawn_non_negative CHECK (withdrawn >= 0);
+
+ALTER TABLE "order"
+    ALTER COLUMN created_at TYPE timestamptz USING created_at AT TIME ZONE 'UTC',
+    ALTER COLUMN last_checked_at TYPE timestamptz USING last_checked_at AT TIME ZONE 'UTC',
+    ALTER COLUMN requeued_at TYPE timestamptz USING requeued_at AT TIME ZONE 'UTC',
+    ADD CONSTRAINT order_user_fk FOREIGN KEY (user_id) REFERENCES "user" (id),
+    ADD CONSTRAINT order_status_check CHECK (status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED')),
+    ADD CONSTRAINT order_accrual_non_negative CHECK (accrual >= 0);
+
+ALTER TABLE withdrawal
+    ALTER COLUMN created_at TYPE timestamptz USING created_at AT TIME ZONE 'UTC',
+    ADD CONSTRAINT withdrawal_user_fk FOREIGN KEY (user_id) REFERENCES "user" (id),
+    ADD CONSTRAINT withdrawal_sum_non_negative CHECK (sum >= 0);
+
+-- order.user_id and withdrawal.user_id are the leading columns of the indexes of 03,
+-- which serve the foreign keys as well.
+
+ALTER TABLE idempotency_key
+    ALTER COLUMN created_at TYPE timestamptz USING created_at AT TIME ZONE 'UTC';
+
+ALTER TABLE order_status_history
+    ALTER COLUMN created_at TYPE timestamptz USING created_at AT TIME ZONE 'UTC',
+    ADD CONSTRAINT order_status_history_order_fk FOREIGN KEY (order_id) REFERENCES "order" (id),
+    ADD CONSTRAINT order_status_history_status_check CHECK (status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED')),
+    ADD CONSTRAINT order_status_history_accrual_non_negative CHECK (accrual >= 0);
+
+ALTER TABLE webhook
+    ALTER COLUMN created_at TYPE timestamptz USING created_at AT TIME ZONE 'UTC',
+    ADD CONSTRAINT webhook_user_fk FOREIGN KEY (user_id) REFERENCES "user" (id);
+
+ALTER TABLE webhook_delivery
+    ALTER COLUMN next_attempt_at TYPE timestamptz USING next_attempt_at AT TIME ZONE 'UTC',
+    ALTER COLUMN created_at TYPE timestamptz USING created_at AT TIME ZONE 'UTC',
+    ALTER COLUMN delivered_at TYPE timestamptz USING delivered_at AT TIME ZONE 'UTC',
+    ADD CONSTRAINT webhook_delivery_webhook_fk FOREIGN KEY (webhook_id) REFERENCES webhook (id) ON DELETE CASCADE,
+    ADD CONSTRAINT webhook_delivery_status_check CHECK (status IN ('PENDING', 'DELIVERED', 'FAILED')),
+    ADD CONSTRAINT webhook_delivery_attempts_non_negative CHECK (attempts >= 0);
+
+ALTER TABLE outbox
+    ALTER COLUMN created_at TYPE timestamptz USING created_at AT TIME ZONE 'UTC',
+    ALTER COLUMN processed_at TYPE timestamptz USING processed_at AT TIME ZONE 'UTC',
+    ADD CONSTRAINT outbox_user_fk FOREIGN KEY (user_id) REFERENCES "user" (id);
+
+CREATE INDEX outbox_user_id_idx ON outbox (user_id);
+
+ALTER TABLE balance_adjustment
+    ALTER COLUMN created_at TYPE timestamptz USING created_at AT TIME ZONE 'UTC',
+    ADD CONSTRAINT balance_adjustment_user_fk FOREIGN KEY (user_id) REFERENCES "user" (id);
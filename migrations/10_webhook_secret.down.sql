@@ -0,0 +1 @@
+ALTER TABLE webhook ALTER COLUMN secret TYPE varchar(255);
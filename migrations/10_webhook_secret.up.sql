@@ -0,0 +1,2 @@
+-- Secrets are stored encrypted, which does not fit into the old length.
+ALTER TABLE webhook ALTER COLUMN secret TYPE text;
@@ -0,0 +1,3 @@
+ALTER TABLE outbox
+    ADD COLUMN attempts        integer not null default 0,
+    ADD COLUMN next_attempt_at timestamptz;
@@ -0,0 +1,2 @@
+-- The backfilled rows cannot be told apart from the recorded ones and are kept.
+SELECT 1;
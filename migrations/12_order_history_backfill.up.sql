@@ -0,0 +1,9 @@
+-- Orders created before 04 have no history. Each gets one row with its current status,
+-- dated with the last poll for final statuses, which is when the status was last set.
+INSERT INTO order_status_history (order_id, status, accrual, created_at)
+SELECT o.id,
+       o.status,
+       o.accrual,
+       CASE WHEN o.status IN ('PROCESSED', 'INVALID') THEN COALESCE(o.last_checked_at, o.created_at) ELSE o.created_at END
+FROM "order" o
+WHERE NOT EXISTS (SELECT 1 FROM order_status_history h WHERE h.order_id = o.id);
@@ -0,0 +1 @@
+DROP INDEX outbox_user_unprocessed_idx;
@@ -0,0 +1,2 @@
+-- Serves the lookup of earlier unprocessed events of a user in Dispatch.
+CREATE INDEX outbox_user_unprocessed_idx ON outbox (user_id, id) WHERE processed_at IS NULL;
@@ -0,0 +1,46 @@
+// Package migrations embeds the schema migrations, so the binary does not depend on the working directory.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+
+	"github.com/golang-migrate/migrate/source"
+	bindata "github.com/golang-migrate/migrate/source/go_bindata"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Source returns the migrations as a golang-migrate source driver.
+func Source() (source.Driver, error) {
+	names, err := fs.Glob(files, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	return bindata.WithInstance(bindata.Resource(names, func(name string) ([]byte, error) {
+		return files.ReadFile(name)
+	}))
+}
+
+// Latest returns the highest version among the migrations.
+func Latest() (uint, error) {
+	names, err := fs.Glob(files, "*.sql")
+	if err != nil {
+		return 0, err
+	}
+
+	var latest uint
+	for _, name := range names {
+		migration, err := source.Parse(name)
+		if err != nil {
+			continue
+		}
+		if migration.Version > latest {
+			latest = migration.Version
+		}
+	}
+
+	return latest, nil
+}
@@ -0,0 +1,397 @@
+// Package client is a Go client of the gophermart API.
+//
+//	c, err := client.New("http://localhost:8080")
+//	if err != nil {
+//		return err
+//	}
+//	if err := c.Login(ctx, "login", "password"); err != nil {
+//		return err
+//	}
+//	balance, err := c.Balance(ctx)
+//
+// The session cookie set on register and login is kept by the client. Requests failed with 429
+// or a server error are retried, withdrawals and uploads carry an Idempotency-Key, so that
+// a retry does not apply them twice.
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Client struct {
+	baseURL      *url.URL
+	httpClient   *http.Client
+	maxRetries   int
+	retryWait    time.Duration
+	gzipRequests bool
+}
+
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client. A cookie jar is added when it has none.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries sets how many times a request is retried and the wait before the first retry,
+// the wait doubles with every attempt unless the server sends Retry-After.
+func WithRetries(maxRetries int, wait time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.retryWait = wait
+	}
+}
+
+// WithGzipRequests compresses request bodies.
+func WithGzipRequests() Option {
+	return func(c *Client) {
+		c.gzipRequests = true
+	}
+}
+
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("base url %q must be an http or https URL", baseURL)
+	}
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		maxRetries: 3,
+		retryWait:  500 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.httpClient.Jar == nil {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, err
+		}
+		httpClient := *c.httpClient
+		httpClient.Jar = jar
+		c.httpClient = &httpClient
+	}
+
+	return c, nil
+}
+
+type Order struct {
+	Number     string
+	Status     string
+	Accrual    *float64
+	UploadedAt time.Time
+}
+
+type Balance struct {
+	Current   float64 `json:"current"`
+	Withdrawn float64 `json:"withdrawn"`
+}
+
+type Withdrawal struct {
+	Order       string
+	Sum         float64
+	ProcessedAt time.Time
+}
+
+// BatchResult is the outcome of one number of UploadOrders.
+type BatchResult struct {
+	Number string `json:"number"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ListOptions selects a page of a list. Zero values are not sent.
+type ListOptions struct {
+	Limit    int
+	After    string
+	From     time.Time
+	To       time.Time
+	Statuses []string
+}
+
+func (o ListOptions) query() url.Values {
+	query := url.Values{}
+	if o.Limit > 0 {
+		query.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.After != "" {
+		query.Set("after", o.After)
+	}
+	if !o.From.IsZero() {
+		query.Set("from", o.From.Format(time.RFC3339))
+	}
+	if !o.To.IsZero() {
+		query.Set("to", o.To.Format(time.RFC3339))
+	}
+	if len(o.Statuses) > 0 {
+		query.Set("status", strings.Join(o.Statuses, ","))
+	}
+	return query
+}
+
+type credentials struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+func (c *Client) Register(ctx context.Context, login, password string) error {
+	_, err := c.doJSON(ctx, http.MethodPost, "/api/v2/user/register", nil, credentials{login, password}, false, nil)
+	return err
+}
+
+func (c *Client) Login(ctx context.Context, login, password string) error {
+	_, err := c.doJSON(ctx, http.MethodPost, "/api/v2/user/login", nil, credentials{login, password}, true, nil)
+	return err
+}
+
+// UploadOrder uploads the order number. It returns true when the order is accepted
+// and false when the user has already uploaded it.
+func (c *Client) UploadOrder(ctx context.Context, number string) (bool, error) {
+	request := &request{
+		method:      http.MethodPost,
+		path:        "/api/v2/user/orders",
+		contentType: "text/plain",
+		body:        []byte(number),
+		idempotent:  true,
+	}
+	response, _, err := c.do(ctx, request)
+	if err != nil {
+		return false, err
+	}
+
+	return response.StatusCode == http.StatusAccepted, nil
+}
+
+// UploadOrders uploads several order numbers at once, the result of every number is returned.
+func (c *Client) UploadOrders(ctx context.Context, numbers []string) ([]BatchResult, error) {
+	var results []BatchResult
+	_, err := c.doJSON(ctx, http.MethodPost, "/api/user/orders/batch", nil, numbers, true, &results)
+	return results, err
+}
+
+// Orders returns a page of orders and the cursor of the next page, empty on the last page.
+func (c *Client) Orders(ctx context.Context, opts ListOptions) ([]Order, string, error) {
+	var dtos []struct {
+		Number     string    `json:"number"`
+		Status     string    `json:"status"`
+		Accrual    *float64  `json:"accrual"`
+		UploadedAt time.Time `json:"uploaded_at"`
+	}
+	response, err := c.doJSON(ctx, http.MethodGet, "/api/v2/user/orders", opts.query(), nil, true, &dtos)
+	if err != nil {
+		return nil, "", err
+	}
+
+	orders := make([]Order, len(dtos))
+	for i, dto := range dtos {
+		orders[i] = Order{Number: dto.Number, Status: dto.Status, Accrual: dto.Accrual, UploadedAt: dto.UploadedAt}
+	}
+	return orders, response.Header.Get("X-Next-Cursor"), nil
+}
+
+func (c *Client) Balance(ctx context.Context) (Balance, error) {
+	var balance Balance
+	_, err := c.doJSON(ctx, http.MethodGet, "/api/v2/user/balance", nil, nil, true, &balance)
+	return balance, err
+}
+
+func (c *Client) Withdraw(ctx context.Context, order string, sum float64) error {
+	body := struct {
+		Order string  `json:"order"`
+		Sum   float64 `json:"sum"`
+	}{order, sum}
+	_, err := c.doJSON(ctx, http.MethodPost, "/api/v2/user/balance/withdraw", nil, body, true, nil)
+	return err
+}
+
+// Withdrawals returns a page of withdrawals and the cursor of the next page, empty on the last page.
+func (c *Client) Withdrawals(ctx context.Context, opts ListOptions) ([]Withdrawal, string, error) {
+	opts.Statuses = nil
+	var dtos []struct {
+		Order       string    `json:"order"`
+		Sum         float64   `json:"sum"`
+		ProcessedAt time.Time `json:"processed_at"`
+	}
+	response, err := c.doJSON(ctx, http.MethodGet, "/api/v2/user/balance/withdrawals", opts.query(), nil, true, &dtos)
+	if err != nil {
+		return nil, "", err
+	}
+
+	withdrawals := make([]Withdrawal, len(dtos))
+	for i, dto := range dtos {
+		withdrawals[i] = Withdrawal{Order: dto.Order, Sum: dto.Sum, ProcessedAt: dto.ProcessedAt}
+	}
+	return withdrawals, response.Header.Get("X-Next-Cursor"), nil
+}
+
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	contentType string
+	body        []byte
+	// idempotent requests are retried after server errors as well, POST requests get an Idempotency-Key.
+	idempotent bool
+}
+
+// doJSON sends in as JSON and decodes the response into out. A 204 response leaves out untouched.
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, in interface{}, idempotent bool, out interface{}) (*http.Response, error) {
+	r := &request{method: method, path: path, query: query, idempotent: idempotent}
+	if in != nil {
+		body, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		r.body, r.contentType = body, "application/json"
+	}
+
+	response, body, err := c.do(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if out != nil && response.StatusCode != http.StatusNoContent && len(body) > 0 {
+		if err := json.Unmarshal(body, out); err != nil {
+			return nil, fmt.Errorf("gophermart: invalid response: %w", err)
+		}
+	}
+	return response, nil
+}
+
+func (c *Client) do(ctx context.Context, r *request) (*http.Response, []byte, error) {
+	u := *c.baseURL
+	u.Path += r.path
+	u.RawQuery = r.query.Encode()
+
+	body, encoding := r.body, ""
+	if c.gzipRequests && len(body) > 0 {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(body)
+		if err := gz.Close(); err != nil {
+			return nil, nil, err
+		}
+		body, encoding = buf.Bytes(), "gzip"
+	}
+
+	idempotencyKey := ""
+	if r.idempotent && r.method == http.MethodPost {
+		idempotencyKey = newIdempotencyKey()
+	}
+
+	wait := c.retryWait
+	for attempt := 0; ; attempt++ {
+		request, err := http.NewRequestWithContext(ctx, r.method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, err
+		}
+		request.Header.Set("Accept", "application/json")
+		request.Header.Set("Accept-Encoding", "gzip")
+		if r.contentType != "" {
+			request.Header.Set("Content-Type", r.contentType)
+		}
+		if encoding != "" {
+			request.Header.Set("Content-Encoding", encoding)
+		}
+		if idempotencyKey != "" {
+			request.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+
+		response, responseBody, err := c.send(request)
+		if err != nil && !r.idempotent {
+			return nil, nil, err
+		}
+		retryable := err == nil && (response.StatusCode == http.StatusTooManyRequests ||
+			r.idempotent && response.StatusCode >= http.StatusInternalServerError)
+		if err == nil && !retryable {
+			if response.StatusCode >= http.StatusBadRequest {
+				return nil, nil, newError(response, responseBody)
+			}
+			return response, responseBody, nil
+		}
+
+		if attempt >= c.maxRetries {
+			if err != nil {
+				return nil, nil, err
+			}
+			return nil, nil, newError(response, responseBody)
+		}
+
+		delay := wait
+		if response != nil {
+			if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil {
+				delay = time.Duration(seconds) * time.Second
+			}
+		}
+		wait *= 2
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// send executes the request and reads the whole body.
+func (c *Client) send(request *http.Request) (*http.Response, []byte, error) {
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+
+	var reader io.Reader = response.Body
+	if response.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(response.Body)
+		if err != nil {
+			return nil, nil, fmt.Errorf("gophermart: invalid response: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return response, body, nil
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// IsRetryable reports whether err is worth retrying later.
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrServer)
+}
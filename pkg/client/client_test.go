@@ -0,0 +1,306 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tim3-p/go-ya-diplom/internal/handlers/handlerstest"
+	"github.com/tim3-p/go-ya-diplom/pkg/client"
+)
+
+// The client is tested against the API handler running on in-memory repositories.
+
+const (
+	order      = "12345678903"
+	otherOrder = "79927398713"
+)
+
+func newServer(t *testing.T, wrap func(http.Handler) http.Handler) (*handlerstest.Store, string) {
+	store := handlerstest.NewStore()
+	var handler http.Handler = handlerstest.NewHandler(store)
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return store, server.URL
+}
+
+func newClient(t *testing.T, url string, opts ...client.Option) *client.Client {
+	opts = append([]client.Option{client.WithRetries(3, time.Millisecond)}, opts...)
+	c, err := client.New(url, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func register(t *testing.T, c *client.Client, login string) {
+	if err := c.Register(context.Background(), login, "password1"); err != nil {
+		t.Fatalf("register %s: %v", login, err)
+	}
+}
+
+func TestSession(t *testing.T) {
+	ctx := context.Background()
+	_, url := newServer(t, nil)
+
+	c := newClient(t, url)
+	if _, err := c.Balance(ctx); !errors.Is(err, client.ErrUnauthorized) {
+		t.Fatalf("balance without a session: %v, want ErrUnauthorized", err)
+	}
+
+	register(t, c, "alice")
+	if _, err := c.Balance(ctx); err != nil {
+		t.Fatalf("balance after register: %v", err)
+	}
+	// The session from a v2 route is sent to the v1 batch upload as well.
+	if _, err := c.UploadOrders(ctx, []string{order}); err != nil {
+		t.Fatalf("batch upload after register: %v", err)
+	}
+
+	other := newClient(t, url)
+	if err := other.Login(ctx, "alice", "password1"); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	orders, _, err := other.Orders(ctx, client.ListOptions{})
+	if err != nil || len(orders) != 1 {
+		t.Fatalf("orders after login: %v, %v", orders, err)
+	}
+}
+
+func TestDecodeV2(t *testing.T) {
+	ctx := context.Background()
+	store, url := newServer(t, nil)
+	c := newClient(t, url)
+	register(t, c, "alice")
+
+	accepted, err := c.UploadOrder(ctx, order)
+	if err != nil || !accepted {
+		t.Fatalf("first upload: %t, %v, want accepted", accepted, err)
+	}
+	accepted, err = c.UploadOrder(ctx, order)
+	if err != nil || accepted {
+		t.Fatalf("repeated upload: %t, %v, want already uploaded", accepted, err)
+	}
+	if _, err := c.UploadOrder(ctx, otherOrder); err != nil {
+		t.Fatal(err)
+	}
+	store.Process(order, 500)
+
+	orders, next, err := c.Orders(ctx, client.ListOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 || next == "" {
+		t.Fatalf("first page: %v, next %q, want one order and a cursor", orders, next)
+	}
+	if got := orders[0]; got.Number != order || got.Status != "PROCESSED" || got.Accrual == nil || *got.Accrual != 500 || got.UploadedAt.IsZero() {
+		t.Errorf("processed order: %+v", got)
+	}
+
+	orders, next, err = c.Orders(ctx, client.ListOptions{Limit: 1, After: next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 || next != "" || orders[0].Number != otherOrder || orders[0].Accrual != nil {
+		t.Errorf("last page: %+v, next %q, want the new order without accrual", orders, next)
+	}
+
+	if err := c.Withdraw(ctx, "2377225624", 120.5); err != nil {
+		t.Fatal(err)
+	}
+	balance, err := c.Balance(ctx)
+	if err != nil || balance != (client.Balance{Current: 379.5, Withdrawn: 120.5}) {
+		t.Errorf("balance: %+v, %v", balance, err)
+	}
+
+	withdrawals, _, err := c.Withdrawals(ctx, client.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(withdrawals) != 1 || withdrawals[0].Order != "2377225624" || withdrawals[0].Sum != 120.5 || withdrawals[0].ProcessedAt.IsZero() {
+		t.Errorf("withdrawals: %+v", withdrawals)
+	}
+}
+
+// recordingTransport keeps the encodings of the requests and the responses.
+type recordingTransport struct {
+	mu                sync.Mutex
+	requestEncodings  []string
+	responseEncodings []string
+}
+
+func (t *recordingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := http.DefaultTransport.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requestEncodings = append(t.requestEncodings, request.Header.Get("Content-Encoding"))
+	t.responseEncodings = append(t.responseEncodings, response.Header.Get("Content-Encoding"))
+	return response, nil
+}
+
+func TestGzip(t *testing.T) {
+	ctx := context.Background()
+	_, url := newServer(t, nil)
+	transport := &recordingTransport{}
+	c := newClient(t, url, client.WithGzipRequests(), client.WithHTTPClient(&http.Client{Transport: transport}))
+	register(t, c, "alice")
+
+	numbers := []string{order, otherOrder, "4561261212345467", "2377225624"}
+	results, err := c.UploadOrders(ctx, numbers)
+	if err != nil || len(results) != len(numbers) {
+		t.Fatalf("gzipped batch upload: %v, %v", results, err)
+	}
+	if encoding := transport.requestEncodings[len(transport.requestEncodings)-1]; encoding != "gzip" {
+		t.Errorf("request encoding %q, want gzip", encoding)
+	}
+
+	orders, _, err := c.Orders(ctx, client.ListOptions{})
+	if err != nil || len(orders) != len(numbers) {
+		t.Fatalf("orders: %v, %v", orders, err)
+	}
+	if encoding := transport.responseEncodings[len(transport.responseEncodings)-1]; encoding != "gzip" {
+		t.Errorf("response encoding %q, want gzip for a list longer than %d bytes", encoding, handlerstest.CompressMinSize)
+	}
+}
+
+// failFirst answers the first failures requests to path with status and records the
+// Idempotency-Key of every request to it. With apply set the failed requests reach the
+// handler first, as if the response was lost on the way back.
+type failFirst struct {
+	next     http.Handler
+	path     string
+	failures int
+	status   int
+	apply    bool
+
+	mu   sync.Mutex
+	keys []string
+}
+
+func (f *failFirst) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != f.path {
+		f.next.ServeHTTP(w, r)
+		return
+	}
+
+	f.mu.Lock()
+	f.keys = append(f.keys, r.Header.Get("Idempotency-Key"))
+	fail := len(f.keys) <= f.failures
+	f.mu.Unlock()
+
+	if !fail {
+		f.next.ServeHTTP(w, r)
+		return
+	}
+
+	if f.apply {
+		f.next.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	w.Header().Set("Retry-After", "0")
+	http.Error(w, http.StatusText(f.status), f.status)
+}
+
+func TestRetryKeepsIdempotencyKey(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusBadGateway} {
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			ctx := context.Background()
+			var failing *failFirst
+			store, url := newServer(t, func(next http.Handler) http.Handler {
+				failing = &failFirst{next: next, path: "/api/v2/user/balance/withdraw", failures: 2, status: status, apply: true}
+				return failing
+			})
+			c := newClient(t, url)
+			register(t, c, "alice")
+			if _, err := c.UploadOrder(ctx, order); err != nil {
+				t.Fatal(err)
+			}
+			store.Process(order, 100)
+
+			if err := c.Withdraw(ctx, "2377225624", 30); err != nil {
+				t.Fatalf("withdraw: %v", err)
+			}
+
+			if len(failing.keys) != 3 || failing.keys[0] == "" || failing.keys[1] != failing.keys[0] || failing.keys[2] != failing.keys[0] {
+				t.Errorf("idempotency keys of the attempts: %q, want one key sent three times", failing.keys)
+			}
+			// The failed attempts reached the handler, the withdrawal is applied once.
+			balance, err := c.Balance(ctx)
+			if err != nil || balance != (client.Balance{Current: 70, Withdrawn: 30}) {
+				t.Errorf("balance: %+v, %v, want one withdrawal", balance, err)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	var failing *failFirst
+	_, url := newServer(t, func(next http.Handler) http.Handler {
+		failing = &failFirst{next: next, path: "/api/v2/user/balance", failures: 100, status: http.StatusServiceUnavailable}
+		return failing
+	})
+	c := newClient(t, url)
+	register(t, c, "alice")
+
+	tests := []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{"login taken", func() error { return c.Register(ctx, "alice", "password1") }, client.ErrConflict},
+		{"wrong password", func() error { return newClient(t, url).Login(ctx, "alice", "wrong") }, client.ErrUnauthorized},
+		{"invalid order", func() error { _, err := c.UploadOrder(ctx, "12345678900"); return err }, client.ErrValidation},
+		{"insufficient funds", func() error { return c.Withdraw(ctx, "2377225624", 10) }, client.ErrInsufficientFunds},
+		{"invalid cursor", func() error { _, _, err := c.Orders(ctx, client.ListOptions{After: "!"}); return err }, client.ErrBadRequest},
+		{"server error", func() error { _, err := c.Balance(ctx); return err }, client.ErrServer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error %v, want %v", err, tt.want)
+			}
+
+			var apiError *client.Error
+			if !errors.As(err, &apiError) {
+				t.Fatalf("error %T is not an *Error", err)
+			}
+			if tt.want != client.ErrServer && (apiError.Code == "" || apiError.CorrelationID == "") {
+				t.Errorf("problem fields are not decoded: %+v", apiError)
+			}
+			if client.IsRetryable(err) != (tt.want == client.ErrServer) {
+				t.Errorf("IsRetryable(%v) = %t", err, client.IsRetryable(err))
+			}
+		})
+	}
+
+	// Balance is a GET and is retried after server errors.
+	if len(failing.keys) != 4 {
+		t.Errorf("balance attempts %d, want the request and 3 retries", len(failing.keys))
+	}
+}
+
+func TestRateLimitedAfterRetries(t *testing.T) {
+	_, url := newServer(t, func(next http.Handler) http.Handler {
+		return &failFirst{next: next, path: "/api/v2/user/login", failures: 100, status: http.StatusTooManyRequests}
+	})
+
+	err := newClient(t, url).Login(context.Background(), "alice", "password1")
+	if !errors.Is(err, client.ErrRateLimited) || !client.IsRetryable(err) {
+		t.Errorf("error %v, want a retryable ErrRateLimited", err)
+	}
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Errors matched by errors.Is against an *Error by its status code.
+var (
+	ErrBadRequest           = errors.New("bad request")
+	ErrUnauthorized         = errors.New("unauthorized")
+	ErrInsufficientFunds    = errors.New("insufficient funds")
+	ErrNotFound             = errors.New("not found")
+	ErrConflict             = errors.New("conflict")
+	ErrTooLarge             = errors.New("payload too large")
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	ErrValidation           = errors.New("validation failed")
+	ErrRateLimited          = errors.New("rate limited")
+	ErrServer               = errors.New("server error")
+)
+
+var statusErrors = map[int]error{
+	http.StatusBadRequest:            ErrBadRequest,
+	http.StatusUnauthorized:          ErrUnauthorized,
+	http.StatusPaymentRequired:       ErrInsufficientFunds,
+	http.StatusNotFound:              ErrNotFound,
+	http.StatusConflict:              ErrConflict,
+	http.StatusRequestEntityTooLarge: ErrTooLarge,
+	http.StatusUnsupportedMediaType:  ErrUnsupportedMediaType,
+	http.StatusUnprocessableEntity:   ErrValidation,
+	http.StatusTooManyRequests:       ErrRateLimited,
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is an unsuccessful response of the server. Code, Detail and CorrelationID are taken
+// from the problem document when the server sent one.
+type Error struct {
+	StatusCode    int
+	Code          string
+	Detail        string
+	CorrelationID string
+	Fields        []FieldError
+}
+
+func (e *Error) Error() string {
+	message := fmt.Sprintf("gophermart: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Detail != "" {
+		message += ": " + e.Detail
+	}
+	if e.CorrelationID != "" {
+		message += " (correlation id " + e.CorrelationID + ")"
+	}
+	return message
+}
+
+func (e *Error) Is(target error) bool {
+	if target == ErrServer {
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return statusErrors[e.StatusCode] == target
+}
+
+func newError(response *http.Response, body []byte) *Error {
+	e := &Error{StatusCode: response.StatusCode}
+
+	if strings.Contains(response.Header.Get("Content-Type"), "json") {
+		var problem struct {
+			Code          string       `json:"code"`
+			Detail        string       `json:"detail"`
+			CorrelationID string       `json:"correlation_id"`
+			Errors        []FieldError `json:"errors"`
+		}
+		if json.Unmarshal(body, &problem) == nil {
+			e.Code, e.Detail, e.CorrelationID, e.Fields = problem.Code, problem.Detail, problem.CorrelationID, problem.Errors
+		}
+	}
+
+	if e.Detail == "" {
+		e.Detail = strings.TrimSpace(string(body))
+	}
+	if e.CorrelationID == "" {
+		e.CorrelationID = response.Header.Get("X-Correlation-ID")
+	}
+
+	return e
+}